@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"golang.org/x/sync/errgroup"
+)
+
+const _batch_Parallelism = 16
+
+// BatchStorage 同時處理多個 key，結果與 keys 的順序相同。
+// 個別 key 的錯誤記錄在 BatchResult.Err，只有整批無法執行時才回傳 error
+type BatchStorage interface {
+	// BatchDelete 與 Delete 相同，key 不存在時為錯誤
+	BatchDelete(keys []string) ([]BatchResult, error)
+	BatchExist(keys []string) ([]BatchResult, error)
+	// BatchStat 物件存在時 Object 不為 nil
+	BatchStat(keys []string) ([]BatchResult, error)
+}
+
+type BatchResult struct {
+	Key    string
+	Exist  bool
+	Object *ObjectInfo
+	Err    error
+}
+
+// runBatch 最多以 _batch_Parallelism 個 goroutine 同時對每個 key 呼叫 fn
+func runBatch(keys []string, fn func(key string, result *BatchResult)) []BatchResult {
+	results := make([]BatchResult, len(keys))
+	var g errgroup.Group
+	g.SetLimit(_batch_Parallelism)
+	for i := range keys {
+		result := &results[i]
+		result.Key = keys[i]
+		g.Go(func() error {
+			fn(result.Key, result)
+			return nil
+		})
+	}
+	g.Wait()
+	return results
+}
+
+func batchDelete(s Storage, keys []string) []BatchResult {
+	return runBatch(keys, func(key string, result *BatchResult) {
+		result.Err = s.Delete(key)
+	})
+}
+
+func batchExist(s Storage, keys []string) []BatchResult {
+	return runBatch(keys, func(key string, result *BatchResult) {
+		result.Exist, result.Err = s.FileExist(key)
+	})
+}
+
+// batchStat stat 在物件不存在時回傳 nil, nil
+func batchStat(keys []string, stat func(key string) (*ObjectInfo, error)) []BatchResult {
+	return runBatch(keys, func(key string, result *BatchResult) {
+		result.Object, result.Err = stat(key)
+		result.Exist = result.Object != nil
+	})
+}
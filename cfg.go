@@ -16,8 +16,10 @@ type Config struct {
 
 	ModelDI
 
-	ConfMap GcpConfigMap
-	Log     log.Logger
+	// ConfMap 與 Channels 為同一份設定，ConfMap 只包含 gcs channel
+	ConfMap  GcpConfigMap
+	Channels ChannelRegistry
+	Log      log.Logger
 }
 
 func GetConfigFromEnv() (*Config, error) {
@@ -27,10 +29,11 @@ func GetConfigFromEnv() (*Config, error) {
 		return nil, err
 	}
 
-	mycfg.ConfMap, err = LoadGcpConfigMap(mycfg.ConfMapPath)
+	mycfg.Channels, err = LoadChannelRegistry(mycfg.ConfMapPath)
 	if err != nil {
 		return nil, err
 	}
+	mycfg.ConfMap = mycfg.Channels
 	return &mycfg, nil
 }
 
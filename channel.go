@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ChannelType string
+
+const (
+	ChannelGcs    = ChannelType("gcs")
+	ChannelHd     = ChannelType("hd")
+	ChannelS3     = ChannelType("s3")
+	ChannelMemory = ChannelType("memory")
+	ChannelSftp   = ChannelType("sftp")
+)
+
+// HdConf 為 hd channel 的設定
+type HdConf struct {
+	Path      string `yaml:"path"`
+	HdOptions `yaml:",inline"`
+}
+
+// ChannelConf 依 Type 只有對應的設定不為 nil，yaml 中 type 以外的欄位即為該設定的內容
+type ChannelConf struct {
+	Type ChannelType
+	Gcs  *GcpConf
+	Hd   *HdConf
+	S3   *S3Conf
+	Sftp *SftpConf
+	// Memory 未設定 keepVersions 時不保留舊版本
+	Memory *MemOptions
+	// S3ApiKeys 為透過 container 的 S3 API 存取此 channel 時使用的 SigV4 金鑰，未設定時無法以 S3 API 存取
+	S3ApiKeys []S3ApiKey
+	// Policies 為 container 的 grpc 服務啟用驗證時可存取此 channel 的身分，未設定時所有請求都會被拒絕
+	Policies []ChannelPolicy
+}
+
+type S3ApiKey struct {
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+}
+
+// ChannelPolicy 允許 Identities 對 Prefixes 之下的 key 執行 Operations
+type ChannelPolicy struct {
+	// 例如 key:backend、jwt:*@example.com、cert:uploader，可使用 path.Match 的萬用字元
+	Identities []string `yaml:"identities"`
+	// read、write、delete、list、admin，* 為全部
+	Operations []string `yaml:"operations"`
+	// 未設定時為整個 channel
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// UnmarshalYAML 未指定 type 時視為 gcs，與 LoadGcpConfigMap 的設定檔相容
+func (c *ChannelConf) UnmarshalYAML(node *yaml.Node) error {
+	var header struct {
+		Type      ChannelType     `yaml:"type"`
+		S3ApiKeys []S3ApiKey      `yaml:"s3ApiKeys"`
+		Policies  []ChannelPolicy `yaml:"policies"`
+	}
+	if err := node.Decode(&header); err != nil {
+		return err
+	}
+	c.Type = header.Type
+	c.S3ApiKeys = header.S3ApiKeys
+	c.Policies = header.Policies
+	if c.Type == "" {
+		c.Type = ChannelGcs
+	}
+	switch c.Type {
+	case ChannelGcs:
+		c.Gcs = &GcpConf{}
+		return node.Decode(c.Gcs)
+	case ChannelHd:
+		c.Hd = &HdConf{}
+		return node.Decode(c.Hd)
+	case ChannelS3:
+		c.S3 = &S3Conf{}
+		return node.Decode(c.S3)
+	case ChannelSftp:
+		c.Sftp = &SftpConf{}
+		return node.Decode(c.Sftp)
+	case ChannelMemory:
+		c.Memory = &MemOptions{}
+		return node.Decode(c.Memory)
+	}
+	return fmt.Errorf("line %d: unknown channel type %q", node.Line, c.Type)
+}
+
+// ChannelRegistry 依 channel 名稱取得對應後端的 Storage
+type ChannelRegistry interface {
+	// GetConfig 只回傳 gcs channel 的設定，其餘類型回傳 nil
+	GcpConfigMap
+	GetChannel(channel string) *ChannelConf
+	// Names 依名稱排序回傳所有的 channel
+	Names() []string
+	// NewStorage 回傳 channel 的 Storage，可再以 type assertion 取得 GcpStorage、HdStorage 等完整的介面。
+	// hd、memory 及 sftp channel 每次回傳同一個實例，讓使用量、記憶體中的資料及連線在請求間共用
+	NewStorage(ctx context.Context, channel string) (Storage, error)
+}
+
+func NewChannelRegistry(channels map[string]*ChannelConf) ChannelRegistry {
+	return &channelRegistry{
+		channels: channels,
+		shared:   map[string]Storage{},
+	}
+}
+
+// LoadChannelRegistry 取代 LoadGcpConfigMap，設定檔中每個 channel 可指定 type
+func LoadChannelRegistry(file string) (ChannelRegistry, error) {
+	channels := map[string]*ChannelConf{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &channels)
+	if err != nil {
+		return nil, err
+	}
+	return NewChannelRegistry(channels), nil
+}
+
+type channelRegistry struct {
+	channels map[string]*ChannelConf
+
+	mu     sync.Mutex
+	shared map[string]Storage
+}
+
+func (r *channelRegistry) GetChannel(channel string) *ChannelConf {
+	return r.channels[channel]
+}
+
+func (r *channelRegistry) Names() []string {
+	names := make([]string, 0, len(r.channels))
+	for name := range r.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *channelRegistry) GetConfig(key string) *GcpConf {
+	conf := r.channels[key]
+	if conf == nil {
+		return nil
+	}
+	return conf.Gcs
+}
+
+func (r *channelRegistry) NewStorage(ctx context.Context, channel string) (Storage, error) {
+	conf := r.channels[channel]
+	if conf == nil {
+		return nil, fmt.Errorf("channel not found [%s]", channel)
+	}
+	switch conf.Type {
+	case ChannelGcs:
+		return conf.Gcs.NewStorage(ctx)
+	case ChannelS3:
+		return conf.S3.NewStorage(ctx)
+	case ChannelHd, ChannelMemory, ChannelSftp:
+		return r.getShared(channel, conf)
+	}
+	return nil, fmt.Errorf("channel [%s]: unknown type %q", channel, conf.Type)
+}
+
+func (r *channelRegistry) getShared(channel string, conf *ChannelConf) (Storage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.shared[channel]; ok {
+		return s, nil
+	}
+	var s Storage
+	switch conf.Type {
+	case ChannelHd:
+		s = NewHdStorageWithOptions(conf.Hd.Path, conf.Hd.HdOptions)
+	case ChannelSftp:
+		// 連線在請求間共用，不隨請求的 ctx 關閉
+		sftpStorage, err := conf.Sftp.NewStorage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		s = sftpStorage
+	default:
+		var opts MemOptions
+		if conf.Memory != nil {
+			opts = *conf.Memory
+		}
+		s = NewMemStorageWithOptions(opts)
+	}
+	r.shared[channel] = s
+	return s, nil
+}
@@ -0,0 +1,43 @@
+// hd-reshard 將本地儲存目錄搬移為新的分層設定，執行期間需停止所有存取此目錄的服務
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/94peter/storage"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run 執行搬移並回傳 exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("hd-reshard", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("path", "", "storage root")
+	fromLevels := flags.Int("from-levels", 0, "current shard levels")
+	fromWidth := flags.Int("from-width", 2, "current shard width")
+	toLevels := flags.Int("to-levels", 2, "new shard levels")
+	toWidth := flags.Int("to-width", 2, "new shard width")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		flags.Usage()
+		return 2
+	}
+	moved, err := storage.ReshardHd(*path,
+		storage.HdOptions{ShardLevels: *fromLevels, ShardWidth: *fromWidth},
+		storage.HdOptions{ShardLevels: *toLevels, ShardWidth: *toWidth},
+	)
+	fmt.Fprintf(stdout, "moved %d files\n", moved)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
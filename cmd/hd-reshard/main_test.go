@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/94peter/storage"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	flat := storage.NewHdStorageWithOptions(dir, storage.HdOptions{KeepVersions: 1})
+	for _, key := range []string{"a.txt", "dir/b.txt"} {
+		if _, err := flat.Save(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := flat.Save("a.txt", []byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-path", dir, "-to-levels", "2", "-to-width", "1"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr = %s", code, stderr.String())
+	}
+	if stdout.String() != "moved 2 files\n" {
+		t.Fatalf("stdout = %q", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("flat file still exists: %v", err)
+	}
+
+	sharded := storage.NewHdStorageWithOptions(dir, storage.HdOptions{KeepVersions: 1, ShardLevels: 2, ShardWidth: 1})
+	if data, err := sharded.Get("dir/b.txt"); err != nil || string(data) != "dir/b.txt" {
+		t.Fatalf("dir/b.txt = %q, %v", data, err)
+	}
+	versions, err := sharded.(storage.VersionedStorage).ListVersions("a.txt")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("versions = %+v, %v", versions, err)
+	}
+	if data, err := sharded.(storage.VersionedStorage).GetVersion("a.txt", versions[1].Generation); err != nil || string(data) != "a.txt" {
+		t.Fatalf("old version = %q, %v", data, err)
+	}
+
+	// 搬移回原本的設定
+	stdout.Reset()
+	if code := run([]string{"-path", dir, "-from-levels", "2", "-from-width", "1", "-to-levels", "0"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, stderr = %s", code, stderr.String())
+	}
+	if data, err := flat.Get("a.txt"); err != nil || string(data) != "a2" {
+		t.Fatalf("a.txt = %q, %v", data, err)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run(nil, &stdout, &stderr); code != 2 || stderr.Len() == 0 {
+		t.Fatalf("exit code = %d, stderr = %q", code, stderr.String())
+	}
+	if code := run([]string{"-unknown"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("exit code = %d", code)
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/94peter/log"
@@ -18,6 +22,7 @@ import (
 	"github.com/94peter/storage/grpc/pb"
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
 var (
@@ -59,9 +64,18 @@ func main() {
 	}
 
 	service := newService(microService)
-	microservice.RunService(
-		service.runGrpc,
-	)
+	handlers := []microservice.ServiceHandler{service.runGrpc}
+	// 有設定 HTTP_PORT、WEBDAV_PORT 及 S3_PORT 時才啟動 http、webdav 及 s3 api
+	if port := os.Getenv("HTTP_PORT"); port != "" {
+		handlers = append(handlers, service.runRest(port))
+	}
+	if port := os.Getenv("WEBDAV_PORT"); port != "" {
+		handlers = append(handlers, service.runWebdav(port))
+	}
+	if port := os.Getenv("S3_PORT"); port != "" {
+		handlers = append(handlers, service.runS3(port))
+	}
+	microservice.RunService(handlers...)
 
 }
 
@@ -85,10 +99,34 @@ func (s *myservice) runGrpc(ctx context.Context) {
 	if err != nil {
 		panic(err)
 	}
-	grpcCfg.SetRegisterServiceFunc(func(s *grpc.Server) {
+	register := func(s *grpc.Server) {
 		pb.RegisterGcpServiceServer(s, service.NewGcp(cfg))
 		healthpb.RegisterHealthServer(s, service.NewHealthService())
-	})
+	}
+
+	// 有設定 GRPC_AUTH_CONF_PATH 時啟用驗證，grpc_tool 無法設定 tls，因此自行啟動 grpc server
+	if auth := loadAuth(cfg); auth != nil {
+		server := grpc.NewServer(auth.ServerOptions()...)
+		register(server)
+		if grpcCfg.ReflectService {
+			reflection.Register(server)
+		}
+		lis, err := net.Listen("tcp", ":"+strconv.Itoa(grpcCfg.Port))
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			<-ctx.Done()
+			server.Stop()
+		}()
+		cfg.Log.Infof("grpc listen on %s with auth", lis.Addr())
+		if err := server.Serve(lis); err != nil && err != grpc.ErrServerStopped {
+			panic(err)
+		}
+		return
+	}
+
+	grpcCfg.SetRegisterServiceFunc(register)
 
 	grpcCfg.Logger = cfg.Log
 
@@ -96,6 +134,95 @@ func (s *myservice) runGrpc(ctx context.Context) {
 
 }
 
+// loadAuth 未設定 GRPC_AUTH_CONF_PATH 時回傳 nil
+func loadAuth(cfg *storage.Config) service.GrpcAuth {
+	path := os.Getenv("GRPC_AUTH_CONF_PATH")
+	if path == "" {
+		return nil
+	}
+	authConf, err := service.LoadGrpcAuthConf(path)
+	if err != nil {
+		panic(err)
+	}
+	auth, err := service.NewGrpcAuth(authConf, cfg)
+	if err != nil {
+		panic(err)
+	}
+	return auth
+}
+
+func (s *myservice) runRest(port string) microservice.ServiceHandler {
+	return s.runHttp("http", port, service.NewRest, service.GrpcAuth.Rest)
+}
+
+func (s *myservice) runWebdav(port string) microservice.ServiceHandler {
+	return s.runHttp("webdav", port, service.NewWebdav, service.GrpcAuth.Webdav)
+}
+
+func (s *myservice) runS3(port string) microservice.ServiceHandler {
+	return s.runHttp("s3", port, func(cfg *storage.Config) http.Handler {
+		opts := service.S3ApiOptions{UploadDir: os.Getenv("S3_UPLOAD_DIR")}
+		if ttl := os.Getenv("S3_UPLOAD_TTL"); ttl != "" {
+			d, err := time.ParseDuration(ttl)
+			if err != nil {
+				panic(err)
+			}
+			opts.UploadTTL = d
+		}
+		if max := os.Getenv("S3_MAX_UPLOADS_PER_KEY"); max != "" {
+			n, err := strconv.Atoi(max)
+			if err != nil {
+				panic(err)
+			}
+			opts.MaxUploadsPerKey = n
+		}
+		handler, err := service.NewS3ApiWithOptions(cfg, opts)
+		if err != nil {
+			panic(err)
+		}
+		return handler
+	}, nil)
+}
+
+// runHttp 啟動 http 服務，ctx 結束時關閉；protect 不為 nil 時與 grpc 使用相同的驗證及憑證
+func (s *myservice) runHttp(name string, port string, newHandler func(cfg *storage.Config) http.Handler,
+	protect func(service.GrpcAuth, http.Handler) http.Handler) microservice.ServiceHandler {
+	return func(ctx context.Context) {
+		cfg, err := s.NewCfg(name)
+		if err != nil {
+			panic(err)
+		}
+		handler := newHandler(cfg)
+		// 結束時釋放 handler 的資源，例如 s3 api 分段上傳的暫存檔
+		if closer, ok := handler.(io.Closer); ok {
+			defer closer.Close()
+		}
+		server := &http.Server{
+			Addr:    ":" + port,
+			Handler: handler,
+		}
+		if protect != nil {
+			if auth := loadAuth(cfg); auth != nil {
+				server.Handler = protect(auth, handler)
+				server.TLSConfig = auth.TLSConfig()
+			}
+		}
+		go func() {
+			<-ctx.Done()
+			server.Shutdown(context.Background())
+		}()
+		cfg.Log.Infof("%s listen on %s", name, server.Addr)
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			panic(err)
+		}
+	}
+}
+
 type mydi struct {
 	di.CommonServiceDI
 
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/storage"
+	"github.com/94peter/storage/grpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// GrpcAuthConf 為 grpc 服務的驗證方式，可同時啟用多種，身分依序由 api key、jwt 及 client 憑證取得
+type GrpcAuthConf struct {
+	ApiKeys []ApiKeyConf `yaml:"apiKeys"`
+	Jwt     *JwtConf     `yaml:"jwt"`
+	Tls     *TlsConf     `yaml:"tls"`
+}
+
+// ApiKeyConf client 以 x-api-key metadata 帶入 Key，身分為 key:{Identity}
+type ApiKeyConf struct {
+	Identity string `yaml:"identity"`
+	Key      string `yaml:"key"`
+}
+
+// JwtConf client 以 authorization: Bearer {token} 帶入 OIDC 的 id token，身分為 jwt:{IdentityClaim}
+type JwtConf struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// 未設定時由 {issuer}/.well-known/openid-configuration 取得
+	JwksUrl string `yaml:"jwksUrl"`
+	// 預設為 sub
+	IdentityClaim string `yaml:"identityClaim"`
+}
+
+// TlsConf 設定 ClientCAFile 時驗證 client 憑證，身分為 cert:{CommonName}
+type TlsConf struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+	// 允許不帶 client 憑證的連線，改以 api key 或 jwt 驗證
+	OptionalClientCert bool `yaml:"optionalClientCert"`
+}
+
+func LoadGrpcAuthConf(file string) (*GrpcAuthConf, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	conf := &GrpcAuthConf{}
+	if err = yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return conf, nil
+}
+
+// GrpcAuth 驗證 GcpService 的請求並依 channel 的 Policies 授權，health 等其他服務不需驗證
+type GrpcAuth interface {
+	interceptor.Interceptor
+	// ServerOptions 包含 interceptor 及設定 Tls 時的憑證
+	ServerOptions() []grpc.ServerOption
+	// Rest 及 Webdav 以相同的身分及 Policies 保護 http 服務，api key 以 X-Api-Key header 帶入
+	Rest(next http.Handler) http.Handler
+	Webdav(next http.Handler) http.Handler
+	// TLSConfig 為 http 服務使用的憑證，未設定 Tls 時為 nil
+	TLSConfig() *tls.Config
+}
+
+func NewGrpcAuth(conf *GrpcAuthConf, cfg *storage.Config) (GrpcAuth, error) {
+	auth := &grpcAuth{
+		channels: cfg.Channels,
+		apiKeys:  map[string]string{},
+	}
+	for _, k := range conf.ApiKeys {
+		if k.Identity == "" || k.Key == "" {
+			return nil, errors.New("apiKeys: identity and key are required")
+		}
+		auth.apiKeys[k.Key] = k.Identity
+	}
+	if conf.Jwt != nil {
+		jwt, err := newJwtAuthenticator(conf.Jwt)
+		if err != nil {
+			return nil, err
+		}
+		auth.jwt = jwt
+	}
+	if conf.Tls != nil {
+		tlsConfig, err := conf.Tls.serverConfig()
+		if err != nil {
+			return nil, err
+		}
+		auth.tlsConfig = tlsConfig
+		auth.creds = credentials.NewTLS(tlsConfig)
+	}
+	return auth, nil
+}
+
+func (conf *TlsConf) serverConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.ClientCAFile != "" {
+		pem, err := os.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificate found in %s", conf.ClientCAFile)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if conf.OptionalClientCert {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+type grpcAuth struct {
+	channels storage.ChannelRegistry
+	// key -> identity
+	apiKeys   map[string]string
+	jwt       *jwtAuthenticator
+	tlsConfig *tls.Config
+	creds     credentials.TransportCredentials
+}
+
+func (a *grpcAuth) TLSConfig() *tls.Config {
+	if a.tlsConfig == nil {
+		return nil
+	}
+	return a.tlsConfig.Clone()
+}
+
+func (a *grpcAuth) ServerOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(a.StreamServerInterceptor()),
+	}
+	if a.creds != nil {
+		opts = append(opts, grpc.Creds(a.creds))
+	}
+	return opts
+}
+
+var _gcpServicePrefix = "/" + pb.GcpService_ServiceDesc.ServiceName + "/"
+
+func (a *grpcAuth) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !strings.HasPrefix(info.FullMethod, _gcpServicePrefix) {
+			return handler(ctx, req)
+		}
+		identity, err := a.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err = a.authorize(ctx, identity, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *grpcAuth) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, _gcpServicePrefix) {
+			return handler(srv, ss)
+		}
+		identity, err := a.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, auth: a, identity: identity, method: info.FullMethod})
+	}
+}
+
+// authorizedStream 請求的內容要在 handler 讀取後才能得知，因此在 RecvMsg 時授權
+type authorizedStream struct {
+	grpc.ServerStream
+	auth     *grpcAuth
+	identity string
+	method   string
+}
+
+func (s *authorizedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.auth.authorize(s.Context(), s.identity, s.method, m)
+}
+
+func (a *grpcAuth) authenticate(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var apiKey, authorization string
+	if keys := md.Get("x-api-key"); len(keys) > 0 {
+		apiKey = keys[0]
+	}
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	var chains [][]*x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			chains = info.State.VerifiedChains
+		}
+	}
+	return a.identify(ctx, apiKey, authorization, chains)
+}
+
+// identify 依序以 api key、bearer token 及驗證過的 client 憑證取得身分，錯誤為 grpc status
+func (a *grpcAuth) identify(ctx context.Context, apiKey, authorization string, chains [][]*x509.Certificate) (string, error) {
+	if apiKey != "" {
+		for key, identity := range a.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+				return "key:" + identity, nil
+			}
+		}
+		return "", status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	if authorization != "" && a.jwt != nil {
+		scheme, token, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "bearer") {
+			return "", status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+		}
+		identity, err := a.jwt.authenticate(ctx, token)
+		if err != nil {
+			return "", status.Error(codes.Unauthenticated, err.Error())
+		}
+		return "jwt:" + identity, nil
+	}
+	if len(chains) > 0 {
+		return "cert:" + chains[0][0].Subject.CommonName, nil
+	}
+	return "", status.Error(codes.Unauthenticated, "missing credentials")
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpAccess 為 http 請求對某個 key 執行的 operation，列表類的請求 key 為目錄
+type httpAccess struct {
+	operation string
+	key       string
+}
+
+// httpRoute 回傳請求需要授權的存取，channel 及 key 為路徑的第一層及其後的部分
+type httpRoute func(r *http.Request, channel, key string) []httpAccess
+
+func (a *grpcAuth) Rest(next http.Handler) http.Handler {
+	return a.httpHandler(next, restRoute)
+}
+
+func (a *grpcAuth) Webdav(next http.Handler) http.Handler {
+	return a.httpHandler(next, webdavRoute)
+}
+
+func (a *grpcAuth) httpHandler(next http.Handler, route httpRoute) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := a.identify(r.Context(), r.Header.Get("X-Api-Key"), r.Header.Get("Authorization"), verifiedChains(r))
+		if err == nil {
+			channel, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			for _, access := range route(r, channel, key) {
+				if err = a.authorizeKeys(identity, channel, access.operation, r.Method, []string{access.key}); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			code := http.StatusInternalServerError
+			switch status.Code(err) {
+			case codes.Unauthenticated:
+				code = http.StatusUnauthorized
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			case codes.PermissionDenied:
+				code = http.StatusForbidden
+			case codes.InvalidArgument:
+				code = http.StatusNotFound
+			}
+			http.Error(w, status.Convert(err).Message(), code)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// restRoute 對應 NewRest 的路由，不支援的 method 只有 operations 為 * 的 policy 可以呼叫
+func restRoute(r *http.Request, _, key string) []httpAccess {
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		return []httpAccess{{_op_List, r.URL.Query().Get("prefix")}}
+	case key == "":
+		return []httpAccess{{"", ""}}
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		return []httpAccess{{_op_Read, key}}
+	case r.Method == http.MethodPut:
+		return []httpAccess{{_op_Write, key}}
+	case r.Method == http.MethodDelete:
+		return []httpAccess{{_op_Delete, key}}
+	}
+	return []httpAccess{{"", key}}
+}
+
+// webdavRoute COPY 及 MOVE 另外需要寫入 Destination 的權限，MOVE 同時需要刪除來源的權限
+func webdavRoute(r *http.Request, channel, key string) []httpAccess {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return []httpAccess{{_op_Read, key}}
+	case "PROPFIND":
+		return []httpAccess{{_op_List, key}}
+	case http.MethodPut, "MKCOL", "PROPPATCH", "LOCK", "UNLOCK":
+		return []httpAccess{{_op_Write, key}}
+	case http.MethodDelete:
+		return []httpAccess{{_op_Delete, key}}
+	case "COPY":
+		return []httpAccess{{_op_Read, key}, {_op_Write, webdavDestination(r, channel)}}
+	case "MOVE":
+		return []httpAccess{{_op_Read, key}, {_op_Delete, key}, {_op_Write, webdavDestination(r, channel)}}
+	}
+	return []httpAccess{{"", key}}
+}
+
+// webdavDestination 回傳 Destination 在 channel 中的 key，不在同一個 channel 時為空字串，由 webdav.Handler 拒絕
+func webdavDestination(r *http.Request, channel string) string {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		return ""
+	}
+	key, ok := strings.CutPrefix(u.Path, "/"+channel+"/")
+	if !ok {
+		return ""
+	}
+	return key
+}
+
+func verifiedChains(r *http.Request) [][]*x509.Certificate {
+	if r.TLS == nil {
+		return nil
+	}
+	return r.TLS.VerifiedChains
+}
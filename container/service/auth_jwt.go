@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	_jwks_CacheTTL = time.Hour
+	// kid 不在快取中時重新取得的最短間隔，避免偽造的 token 造成大量請求
+	_jwks_MinRefresh = time.Minute
+)
+
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type jwtAuthenticator struct {
+	conf   *JwtConf
+	claim  string
+	client *http.Client
+
+	mu        sync.Mutex
+	jwksUrl   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// attemptedAt 為最後一次嘗試取得 jwks 的時間，失敗時也會更新
+	attemptedAt time.Time
+	// fetching 在取得 jwks 期間不為 nil，完成時關閉，同時只會有一個請求
+	fetching chan struct{}
+}
+
+func newJwtAuthenticator(conf *JwtConf) (*jwtAuthenticator, error) {
+	if conf.Issuer == "" {
+		return nil, errors.New("jwt: issuer is required")
+	}
+	a := &jwtAuthenticator{
+		conf:    conf,
+		claim:   conf.IdentityClaim,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		jwksUrl: conf.JwksUrl,
+	}
+	if a.claim == "" {
+		a.claim = "sub"
+	}
+	return a, nil
+}
+
+func (a *jwtAuthenticator) authenticate(ctx context.Context, token string) (string, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtMethods),
+		jwt.WithIssuer(a.conf.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	}
+	if a.conf.Audience != "" {
+		opts = append(opts, jwt.WithAudience(a.conf.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.key(ctx, kid)
+	}, opts...)
+	if err != nil {
+		return "", err
+	}
+	identity, _ := claims[a.claim].(string)
+	if identity == "" {
+		return "", fmt.Errorf("jwt: claim %s not found", a.claim)
+	}
+	return identity, nil
+}
+
+// key 依 kid 取得公鑰，找不到時重新取得 jwks 以支援金鑰輪替
+func (a *jwtAuthenticator) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	for {
+		a.mu.Lock()
+		key, ok := a.keys[kid]
+		if ok && time.Since(a.fetchedAt) < _jwks_CacheTTL {
+			a.mu.Unlock()
+			return key, nil
+		}
+		fetching := a.fetching
+		if fetching == nil && time.Since(a.attemptedAt) >= _jwks_MinRefresh {
+			break
+		}
+		a.mu.Unlock()
+		// 無法更新或其他請求正在更新時繼續使用快取中的金鑰
+		if ok {
+			return key, nil
+		}
+		if fetching == nil {
+			return nil, fmt.Errorf("jwt: unknown key id %q", kid)
+		}
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	// 迴圈以持有 a.mu 的狀態離開
+	done := make(chan struct{})
+	a.fetching, a.attemptedAt = done, time.Now()
+	key, ok := a.keys[kid]
+	a.mu.Unlock()
+
+	keys, err := a.fetch(ctx)
+
+	a.mu.Lock()
+	if err == nil {
+		a.keys, a.fetchedAt = keys, time.Now()
+	}
+	a.fetching = nil
+	close(done)
+	a.mu.Unlock()
+	if err != nil {
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("jwt: unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// fetch 只在 a.fetching 期間執行，因此可以不持有 a.mu 更新 a.jwksUrl
+func (a *jwtAuthenticator) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if a.jwksUrl == "" {
+		var discovery struct {
+			JwksUri string `json:"jwks_uri"`
+		}
+		if err := a.getJson(ctx, strings.TrimSuffix(a.conf.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JwksUri == "" {
+			return nil, errors.New("jwt: jwks_uri not found in openid configuration")
+		}
+		a.jwksUrl = discovery.JwksUri
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := a.getJson(ctx, a.jwksUrl, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		// 無法解析或非簽章用的金鑰直接略過
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (a *jwtAuthenticator) getJson(ctx context.Context, url string, v any) error {
+	// 取得的金鑰會被之後的請求共用，不隨單一請求取消
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("jwt: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwt: GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported okp key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/94peter/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	_op_Read   = "read"
+	_op_Write  = "write"
+	_op_Delete = "delete"
+	_op_List   = "list"
+	// GetAccessToken 回傳的 token 可以直接存取整個 bucket
+	_op_Admin = "admin"
+)
+
+// 未列出的 rpc 只有 operations 為 * 的 policy 可以呼叫
+var methodOperations = map[string]string{
+	"GetFile":        _op_Read,
+	"GetDownloadUrl": _op_Read,
+	"Exist":          _op_Read,
+	"GetVersion":     _op_Read,
+	"BatchExist":     _op_Read,
+	"BatchStat":      _op_Read,
+	"SaveFile":       _op_Write,
+	"GetSignedUrl":   _op_Write,
+	"RestoreVersion": _op_Write,
+	"Delete":         _op_Delete,
+	"DeletePrefix":   _op_Delete,
+	"DeleteVersion":  _op_Delete,
+	"BatchDelete":    _op_Delete,
+	"List":           _op_List,
+	"ListObjects":    _op_List,
+	"ListStream":     _op_List,
+	"ListVersions":   _op_List,
+	"GetAccessToken": _op_Admin,
+}
+
+// requestKeys 回傳請求存取的 key，批次請求為所有的 key，列表類的請求為目錄；不限定 key 的請求為空字串，只有未設定 prefixes 的 policy 允許
+func requestKeys(req any) []string {
+	switch r := req.(type) {
+	case interface{ GetKeys() []string }:
+		return r.GetKeys()
+	case interface{ GetKey() string }:
+		return []string{r.GetKey()}
+	case interface{ GetPath() string }:
+		return []string{r.GetPath()}
+	}
+	return []string{""}
+}
+
+func (a *grpcAuth) authorize(ctx context.Context, identity, fullMethod string, req any) error {
+	channel, err := getChannel(ctx)
+	if err != nil {
+		return err
+	}
+	method := path.Base(fullMethod)
+	return a.authorizeKeys(identity, channel, methodOperations[method], method, requestKeys(req))
+}
+
+// authorizeKeys 每個 key 只要有一個 policy 允許 operation 即可，action 為錯誤訊息中的 rpc 或 http method
+func (a *grpcAuth) authorizeKeys(identity, channel, operation, action string, keys []string) error {
+	conf := a.channels.GetChannel(channel)
+	if conf == nil {
+		return status.Errorf(codes.InvalidArgument, "channel not found [%s]", channel)
+	}
+	for _, key := range keys {
+		ok := false
+		for i := 0; i < len(conf.Policies) && !ok; i++ {
+			ok = allowed(&conf.Policies[i], identity, operation) && inPrefixes(conf.Policies[i].Prefixes, key)
+		}
+		if !ok {
+			return status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s on channel [%s]", identity, action, channel)
+		}
+	}
+	return nil
+}
+
+func allowed(policy *storage.ChannelPolicy, identity, operation string) bool {
+	return matchAny(policy.Identities, identity) && (contains(policy.Operations, "*") ||
+		operation != "" && contains(policy.Operations, operation))
+}
+
+func matchAny(patterns []string, identity string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, identity); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// inPrefixes 未設定 prefixes 時允許所有的 key
+func inPrefixes(prefixes []string, key string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,384 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/94peter/storage"
+	"github.com/94peter/storage/grpc/pb"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var authPolicies = []storage.ChannelPolicy{
+	{Identities: []string{"key:backend"}, Operations: []string{"read", "write", "list"}, Prefixes: []string{"public/"}},
+	{Identities: []string{"jwt:*@example.com", "cert:uploader"}, Operations: []string{"*"}},
+}
+
+func newAuthClient(t *testing.T, conf *GrpcAuthConf, dialCreds credentials.TransportCredentials) pb.GcpServiceClient {
+	cfg := &storage.Config{
+		Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{
+			"test": {Type: storage.ChannelMemory, Policies: authPolicies},
+			"open": {Type: storage.ChannelMemory},
+		}),
+	}
+	auth, err := NewGrpcAuth(conf, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(auth.ServerOptions()...)
+	pb.RegisterGcpServiceServer(server, NewGcp(cfg))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	if dialCreds == nil {
+		dialCreds = insecure.NewCredentials()
+	}
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(dialCreds),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewGcpServiceClient(conn)
+}
+
+func authContext(channel string, kv ...string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), append([]string{"X-Channel", channel}, kv...)...)
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("err = %v, want %s", err, code)
+	}
+}
+
+func TestGrpcAuthApiKey(t *testing.T) {
+	client := newAuthClient(t, &GrpcAuthConf{ApiKeys: []ApiKeyConf{{Identity: "backend", Key: "s3cret"}}}, nil)
+	ctx := authContext("test", "x-api-key", "s3cret")
+
+	_, err := client.SaveFile(ctx, &pb.SaveFileRequest{Key: "public/a.txt", File: []byte("a")})
+	expectCode(t, err, codes.OK)
+	_, err = client.GetFile(ctx, &pb.ObjectKey{Key: "public/a.txt"})
+	expectCode(t, err, codes.OK)
+	_, err = client.SaveFile(ctx, &pb.SaveFileRequest{Key: "private/a.txt", File: []byte("a")})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = client.Delete(ctx, &pb.ObjectKey{Key: "public/a.txt"})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = client.GetAccessToken(ctx, nil)
+	expectCode(t, err, codes.PermissionDenied)
+
+	stream, err := client.ListStream(ctx, &pb.Dir{Path: "public/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	expectCode(t, err, codes.OK)
+	stream, err = client.ListStream(ctx, &pb.Dir{Path: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	expectCode(t, err, codes.PermissionDenied)
+
+	// 未設定 policies 的 channel 拒絕所有請求
+	_, err = client.Exist(authContext("open", "x-api-key", "s3cret"), &pb.ObjectKey{Key: "public/a.txt"})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = client.Exist(authContext("test", "x-api-key", "wrong"), &pb.ObjectKey{Key: "public/a.txt"})
+	expectCode(t, err, codes.Unauthenticated)
+	_, err = client.Exist(authContext("test"), &pb.ObjectKey{Key: "public/a.txt"})
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+// 批次請求的每個 key 都必須被允許
+func TestGrpcAuthBatch(t *testing.T) {
+	client := newAuthClient(t, &GrpcAuthConf{ApiKeys: []ApiKeyConf{{Identity: "backend", Key: "s3cret"}}}, nil)
+	ctx := authContext("test", "x-api-key", "s3cret")
+
+	_, err := client.BatchExist(ctx, &pb.BatchRequest{Keys: []string{"public/a.txt", "public/b.txt"}})
+	expectCode(t, err, codes.OK)
+	_, err = client.BatchStat(ctx, &pb.BatchRequest{Keys: []string{"public/a.txt", "private/b.txt"}})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = client.BatchDelete(ctx, &pb.BatchRequest{Keys: []string{"public/a.txt"}})
+	expectCode(t, err, codes.PermissionDenied)
+
+	keys := make([]string, _batch_MaxKeys+1)
+	for i := range keys {
+		keys[i] = "public/a.txt"
+	}
+	_, err = client.BatchExist(ctx, &pb.BatchRequest{Keys: keys})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestGrpcAuthJwt(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "EC", "kid": "k1", "use": "sig", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	t.Cleanup(jwks.Close)
+	client := newAuthClient(t, &GrpcAuthConf{Jwt: &JwtConf{
+		Issuer:        "https://issuer.example.com",
+		Audience:      "storage",
+		JwksUrl:       jwks.URL,
+		IdentityClaim: "email",
+	}}, nil)
+
+	sign := func(claims jwt.MapClaims) context.Context {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return authContext("test", "authorization", "Bearer "+signed)
+	}
+	claims := func(email string, exp time.Time) jwt.MapClaims {
+		return jwt.MapClaims{"iss": "https://issuer.example.com", "aud": "storage", "email": email, "exp": exp.Unix()}
+	}
+
+	_, err = client.SaveFile(sign(claims("alice@example.com", time.Now().Add(time.Hour))), &pb.SaveFileRequest{Key: "private/a.txt", File: []byte("a")})
+	expectCode(t, err, codes.OK)
+	_, err = client.Delete(sign(claims("mallory@evil.com", time.Now().Add(time.Hour))), &pb.ObjectKey{Key: "private/a.txt"})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = client.Delete(sign(claims("alice@example.com", time.Now().Add(-time.Hour))), &pb.ObjectKey{Key: "private/a.txt"})
+	expectCode(t, err, codes.Unauthenticated)
+	wrongAudience := claims("alice@example.com", time.Now().Add(time.Hour))
+	wrongAudience["aud"] = "other"
+	_, err = client.Delete(sign(wrongAudience), &pb.ObjectKey{Key: "private/a.txt"})
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+func TestGrpcAuthMtls(t *testing.T) {
+	dir := t.TempDir()
+	caKey, caCert := newCert(t, nil, nil, "ca")
+	serverKey, serverCert := newCert(t, caKey, caCert, "localhost")
+	clientKey, clientCert := newCert(t, caKey, caCert, "uploader")
+	writePem := func(name, typ string, der []byte) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	serverKeyDer, _ := x509.MarshalECPrivateKey(serverKey)
+	conf := &GrpcAuthConf{Tls: &TlsConf{
+		CertFile:     writePem("server.crt", "CERTIFICATE", serverCert.Raw),
+		KeyFile:      writePem("server.key", "EC PRIVATE KEY", serverKeyDer),
+		ClientCAFile: writePem("ca.crt", "CERTIFICATE", caCert.Raw),
+	}}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	client := newAuthClient(t, conf, credentials.NewTLS(&tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{clientCert.Raw},
+			PrivateKey:  clientKey,
+		}},
+	}))
+	_, err := client.SaveFile(authContext("test"), &pb.SaveFileRequest{Key: "a.txt", File: []byte("a")})
+	expectCode(t, err, codes.OK)
+
+	// 沒有 client 憑證時無法建立連線
+	client = newAuthClient(t, conf, credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "localhost"}))
+	_, err = client.Exist(authContext("test"), &pb.ObjectKey{Key: "a.txt"})
+	expectCode(t, err, codes.Unavailable)
+}
+
+// newCert parent 為 nil 時產生自簽的 CA
+func newCert(t *testing.T, parentKey *ecdsa.PrivateKey, parent *x509.Certificate, cn string) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// TestJwtKeyFetch 同時只取得一次 jwks，失敗時繼續使用快取中的金鑰且不在 _jwks_MinRefresh 內重試
+func TestJwtKeyFetch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hits atomic.Int32
+	var fail atomic.Bool
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "EC", "kid": "k1", "use": "sig", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	t.Cleanup(jwks.Close)
+	a, err := newJwtAuthenticator(&JwtConf{Issuer: "https://issuer.example.com", JwksUrl: jwks.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.key(context.Background(), "k1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", n)
+	}
+
+	// 快取過期且 jwks 無法取得時繼續使用快取中的金鑰
+	fail.Store(true)
+	a.mu.Lock()
+	a.fetchedAt = time.Now().Add(-2 * _jwks_CacheTTL)
+	a.attemptedAt = a.fetchedAt
+	a.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if _, err := a.key(context.Background(), "k1"); err != nil {
+			t.Fatalf("key with failing jwks: %v", err)
+		}
+	}
+	if _, err := a.key(context.Background(), "k2"); err == nil {
+		t.Fatal("unknown kid: want error")
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("jwks fetched %d times, want 2", n)
+	}
+}
+
+func TestHttpAuth(t *testing.T) {
+	cfg := &storage.Config{
+		Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{
+			"test": {Type: storage.ChannelMemory, Policies: append([]storage.ChannelPolicy{
+				{Identities: []string{"key:admin"}, Operations: []string{"*"}},
+			}, authPolicies...)},
+		}),
+	}
+	auth, err := NewGrpcAuth(&GrpcAuthConf{ApiKeys: []ApiKeyConf{
+		{Identity: "backend", Key: "backend-key"},
+		{Identity: "admin", Key: "admin-key"},
+	}}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := httptest.NewServer(auth.Rest(NewRest(cfg)))
+	t.Cleanup(rest.Close)
+	dav := httptest.NewServer(auth.Webdav(NewWebdav(cfg)))
+	t.Cleanup(dav.Close)
+
+	expect := func(server *httptest.Server, method, path, apiKey string, header map[string]string, code int) {
+		t.Helper()
+		var body io.Reader
+		if method == http.MethodPut {
+			body = strings.NewReader("hello")
+		}
+		req, err := http.NewRequest(method, server.URL+path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if apiKey != "" {
+			req.Header.Set("X-Api-Key", apiKey)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%s %s with key %q = %d, want %d", method, path, apiKey, resp.StatusCode, code)
+		}
+	}
+
+	expect(rest, http.MethodPut, "/test/public/a.txt", "", nil, http.StatusUnauthorized)
+	expect(rest, http.MethodPut, "/test/public/a.txt", "wrong", nil, http.StatusUnauthorized)
+	expect(rest, http.MethodPut, "/test/public/a.txt", "backend-key", nil, http.StatusOK)
+	expect(rest, http.MethodPut, "/test/private/a.txt", "backend-key", nil, http.StatusForbidden)
+	expect(rest, http.MethodGet, "/test/public/a.txt", "backend-key", nil, http.StatusOK)
+	expect(rest, http.MethodDelete, "/test/public/a.txt", "backend-key", nil, http.StatusForbidden)
+	expect(rest, http.MethodGet, "/test?prefix=public/", "backend-key", nil, http.StatusOK)
+	expect(rest, http.MethodGet, "/test?prefix=private/", "backend-key", nil, http.StatusForbidden)
+	expect(rest, http.MethodGet, "/other/a.txt", "backend-key", nil, http.StatusNotFound)
+
+	expect(dav, "PROPFIND", "/test/public/", "", nil, http.StatusUnauthorized)
+	expect(dav, "PROPFIND", "/test/public/", "backend-key", nil, http.StatusMultiStatus)
+	expect(dav, http.MethodPut, "/test/private/b.txt", "backend-key", nil, http.StatusForbidden)
+	// MOVE 需要刪除來源的權限，目的地也必須在允許的 prefix 之下
+	move := map[string]string{"Destination": dav.URL + "/test/public/b.txt"}
+	expect(dav, "MOVE", "/test/public/a.txt", "backend-key", move, http.StatusForbidden)
+	expect(dav, "COPY", "/test/public/a.txt", "backend-key", map[string]string{"Destination": dav.URL + "/test/private/b.txt"}, http.StatusForbidden)
+	expect(dav, "COPY", "/test/public/a.txt", "backend-key", move, http.StatusCreated)
+	expect(dav, "MOVE", "/test/public/a.txt", "admin-key", map[string]string{"Destination": dav.URL + "/test/public/c.txt"}, http.StatusCreated)
+}
@@ -2,30 +2,36 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"reflect"
 	"time"
 
+	googstorage "cloud.google.com/go/storage"
 	"github.com/94peter/log"
 	"github.com/94peter/storage"
 	"github.com/94peter/storage/grpc/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func NewGcp(cfg *storage.Config) pb.GcpServiceServer {
 	return &gcp{
-		configMap: cfg.ConfMap,
-		log:       cfg.Log,
+		channels: cfg.Channels,
+		log:      cfg.Log,
 	}
 }
 
 type gcp struct {
 	pb.UnimplementedGcpServiceServer
 
-	configMap storage.GcpConfigMap
-	log       log.Logger
+	channels storage.ChannelRegistry
+	log      log.Logger
 }
 
 func getChannel(ctx context.Context) (string, error) {
@@ -40,31 +46,85 @@ func getChannel(ctx context.Context) (string, error) {
 	return md.Get("X-Channel")[0], nil
 }
 
-func (gcp *gcp) getStorage(ctx context.Context, channel string) (storage.GcpStorage, error) {
-	gcpConf := gcp.configMap.GetConfig(channel)
-	if gcpConf == nil {
+func (gcp *gcp) getStorage(ctx context.Context, channel string) (storage.Storage, error) {
+	if gcp.channels.GetChannel(channel) == nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("channel not found [%s]", channel))
 	}
-	gcpStorage, err := gcpConf.NewStorage(ctx)
+	sto, err := gcp.channels.NewStorage(ctx, channel)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatusError(codes.Internal, err)
 	}
-	return gcpStorage, nil
+	return sto, nil
 }
 
-// 取得下載連結
-func (gcp *gcp) GetDownloadUrl(ctx context.Context, key *pb.ObjectKey) (*pb.Url, error) {
+// getCapability 取得 ctx 中 channel 的 Storage 並檢查是否實作 T，後端不支援時回傳 Unimplemented
+func getCapability[T any](ctx context.Context, gcp *gcp) (T, error) {
+	var capability T
 	channel, err := getChannel(ctx)
 	if err != nil {
-		return nil, err
+		return capability, err
 	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
+	sto, err := gcp.getStorage(ctx, channel)
+	if err != nil {
+		return capability, err
+	}
+	capability, ok := sto.(T)
+	if !ok {
+		return capability, status.Errorf(codes.Unimplemented, "channel [%s] does not support %s",
+			channel, reflect.TypeOf((*T)(nil)).Elem().Name())
+	}
+	return capability, nil
+}
+
+func unimplemented(feature string) error {
+	return status.Errorf(codes.Unimplemented, "channel does not support %s", feature)
+}
+
+// presignedPutter 為 S3 等以預先簽章連結上傳的後端
+type presignedPutter interface {
+	PresignedPutURL(key string, expDuration time.Duration) (string, error)
+}
+
+// 將 storage 定義的錯誤轉換為對應的 grpc status，其餘錯誤使用 code
+func toStatusError(code codes.Code, err error) error {
+	code, reason := storageErrorCode(code, err)
+	s := status.New(code, err.Error())
+	if reason != "" {
+		// client 依 reason 區分 storage 的錯誤與 grpc 或其他檢查產生的相同 code
+		if detailed, detailErr := s.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: storage.GrpcErrorDomain}); detailErr == nil {
+			s = detailed
+		}
+	}
+	return s.Err()
+}
+
+// storageErrorCode 回傳 storage 定義的錯誤對應的 code 及 ErrorInfo reason，其餘錯誤使用 code
+func storageErrorCode(code codes.Code, err error) (codes.Code, string) {
+	switch {
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		return codes.FailedPrecondition, ""
+	case errors.Is(err, storage.ErrInvalidKey):
+		return codes.InvalidArgument, storage.GrpcReasonInvalidKey
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return codes.ResourceExhausted, storage.GrpcReasonQuotaExceeded
+	}
+	return code, ""
+}
+
+// isNotExist hd 回傳 os.ErrNotExist，gcs 及 memory 回傳 ErrObjectNotExist
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, googstorage.ErrObjectNotExist)
+}
+
+// 取得下載連結
+func (gcp *gcp) GetDownloadUrl(ctx context.Context, key *pb.ObjectKey) (*pb.Url, error) {
+	sto, err := getCapability[storage.GcpStorage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	url, err := gcpStorage.GetDownloadUrl(key.Key)
+	url, err := sto.GetDownloadUrl(key.Key)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, err.Error())
+		return nil, toStatusError(codes.NotFound, err)
 	}
 	response := &pb.Url{
 		Url:      url.Url,
@@ -84,51 +144,48 @@ func (gcp *gcp) GetDownloadUrl(ctx context.Context, key *pb.ObjectKey) (*pb.Url,
 
 // 取得檔案
 func (gcp *gcp) GetFile(ctx context.Context, key *pb.ObjectKey) (*pb.File, error) {
-	channel, err := getChannel(ctx)
+	sto, err := getCapability[storage.Storage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
+	data, err := sto.Get(key.Key)
 	if err != nil {
-		return nil, err
-	}
-	data, err := gcpStorage.Get(key.Key)
-	if err != nil {
-		return nil, status.Error(codes.NotFound, err.Error())
+		return nil, toStatusError(codes.NotFound, err)
 	}
 	return &pb.File{File: data}, nil
 }
 
 // 取得簽章
 func (gcp *gcp) GetSignedUrl(ctx context.Context, req *pb.GetSignedUrlRequest) (*pb.Url, error) {
-	channel, err := getChannel(ctx)
+	sto, err := getCapability[storage.Storage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
-	if err != nil {
-		return nil, err
+	expDuration := time.Duration(req.ExpireSecs) * time.Second
+	var url string
+	switch signer := sto.(type) {
+	case storage.GcpStorage:
+		url, err = signer.SignedURL(req.Key, req.ContentType, expDuration)
+	case presignedPutter:
+		url, err = signer.PresignedPutURL(req.Key, expDuration)
+	default:
+		return nil, unimplemented("SignedURL")
 	}
-	url, err := gcpStorage.SignedURL(req.Key, req.ContentType, time.Duration(req.ExpireSecs)*time.Second)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatusError(codes.Internal, err)
 	}
 	return &pb.Url{Url: url}, nil
 }
 
 // 取得 AccessToken
 func (gcp *gcp) GetAccessToken(ctx context.Context, empty *emptypb.Empty) (*pb.AccessToken, error) {
-	channel, err := getChannel(ctx)
-	if err != nil {
-		return nil, err
-	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
+	sto, err := getCapability[storage.GcpStorage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	token, err := gcpStorage.GetAccessToken()
+	token, err := sto.GetAccessToken()
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatusError(codes.Internal, err)
 	}
 	return &pb.AccessToken{
 		AccessToken:  token.AccessToken,
@@ -139,68 +196,294 @@ func (gcp *gcp) GetAccessToken(ctx context.Context, empty *emptypb.Empty) (*pb.A
 }
 
 func (gcp *gcp) SaveFile(ctx context.Context, req *pb.SaveFileRequest) (*pb.Url, error) {
-	channel, err := getChannel(ctx)
+	sto, err := getCapability[storage.Storage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
-	if err != nil {
-		return nil, err
+	conditional, ok := sto.(storage.ConditionalStorage)
+	if !ok && (req.IfNotExists || req.IfGenerationMatch != 0) {
+		return nil, unimplemented("ConditionalStorage")
+	}
+	var path string
+	switch {
+	case req.IfNotExists:
+		path, err = conditional.SaveIfNotExists(req.Key, req.File)
+	case req.IfGenerationMatch != 0:
+		path, err = conditional.SaveIfMatch(req.Key, req.File, req.IfGenerationMatch)
+	default:
+		path, err = sto.Save(req.Key, req.File)
 	}
-	path, err := gcpStorage.Save(req.Key, req.File)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatusError(codes.Internal, err)
 	}
 	return &pb.Url{Url: path}, nil
 }
 
 // 刪除
 func (gcp *gcp) Delete(ctx context.Context, key *pb.ObjectKey) (*emptypb.Empty, error) {
-	channel, err := getChannel(ctx)
+	sto, err := getCapability[storage.Storage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
-	if err != nil {
-		return nil, err
+	if key.IfGenerationMatch != 0 {
+		conditional, ok := sto.(storage.ConditionalStorage)
+		if !ok {
+			return nil, unimplemented("ConditionalStorage")
+		}
+		err = conditional.DeleteIfMatch(key.Key, key.IfGenerationMatch)
+	} else {
+		err = sto.Delete(key.Key)
 	}
-	err = gcpStorage.Delete(key.Key)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatusError(codes.Internal, err)
 	}
 	return &emptypb.Empty{}, nil
 }
 
 // 檢查檔案是否存在
 func (gcp *gcp) Exist(ctx context.Context, key *pb.ObjectKey) (*pb.ExistResponse, error) {
-	channel, err := getChannel(ctx)
+	sto, err := getCapability[storage.Storage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
-	if err != nil {
-		return nil, err
+	// 不支援 generation 的後端只回傳是否存在
+	conditional, ok := sto.(storage.ConditionalStorage)
+	if !ok {
+		exist, err := sto.FileExist(key.Key)
+		if err != nil {
+			return nil, toStatusError(codes.Internal, err)
+		}
+		return &pb.ExistResponse{Exist: exist}, nil
 	}
-	exist, err := gcpStorage.FileExist(key.Key)
+	generation, err := conditional.Generation(key.Key)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatusError(codes.Internal, err)
 	}
-	return &pb.ExistResponse{Exist: exist}, nil
+	return &pb.ExistResponse{Exist: generation != 0, Generation: generation}, nil
 }
 
 // 列出
 func (gcp *gcp) List(ctx context.Context, dir *pb.Dir) (*pb.ListResponse, error) {
-	channel, err := getChannel(ctx)
+	sto, err := getCapability[storage.Storage](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	gcpStorage, err := gcp.getStorage(ctx, channel)
+	files, err := sto.List(dir.Path)
+	if err != nil {
+		return nil, toStatusError(codes.Internal, err)
+	}
+	return &pb.ListResponse{Files: files}, nil
+}
+
+// 分頁列出物件及子目錄
+func (gcp *gcp) ListObjects(ctx context.Context, dir *pb.Dir) (*pb.ListResponse, error) {
+	sto, err := getCapability[storage.ObjectLister](ctx, gcp)
 	if err != nil {
 		return nil, err
 	}
-	files, err := gcpStorage.List(dir.Path)
+	page, err := sto.ListObjects(dir.Path, toListOptions(dir))
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatusError(codes.Internal, err)
 	}
-	return &pb.ListResponse{Files: files}, nil
+	response := &pb.ListResponse{
+		Objects:   make([]*pb.ObjectInfo, len(page.Objects)),
+		Prefixes:  page.Prefixes,
+		NextToken: page.NextToken,
+	}
+	for i := range page.Objects {
+		response.Objects[i] = toPbObjectInfo(&page.Objects[i])
+	}
+	return response, nil
+}
+
+// 以串流逐筆列出物件及子目錄
+func (gcp *gcp) ListStream(dir *pb.Dir, stream pb.GcpService_ListStreamServer) error {
+	lister, err := getCapability[storage.ObjectLister](stream.Context(), gcp)
+	if err != nil {
+		return err
+	}
+	it := lister.Objects(dir.Path, toListOptions(dir))
+	defer it.Stop()
+	for {
+		object, err := it.Next()
+		if err == storage.IteratorDone {
+			return nil
+		}
+		if err != nil {
+			return toStatusError(codes.Internal, err)
+		}
+		item := &pb.ListItem{Prefix: object.Prefix}
+		if object.Prefix == "" {
+			item.Object = toPbObjectInfo(object)
+		}
+		if err = stream.Send(item); err != nil {
+			return err
+		}
+	}
+}
+
+func toListOptions(dir *pb.Dir) *storage.ListOptions {
+	opts := &storage.ListOptions{
+		Delimiter:   dir.Delimiter,
+		Recursive:   dir.Recursive,
+		PageSize:    int(dir.PageSize),
+		PageToken:   dir.PageToken,
+		StartOffset: dir.StartOffset,
+		EndOffset:   dir.EndOffset,
+		MatchGlob:   dir.MatchGlob,
+		Filter: storage.ListFilter{
+			MinSize: dir.MinSize,
+			MaxSize: dir.MaxSize,
+		},
+	}
+	opts.Filter.UpdatedAfter = fromPbTime(dir.UpdatedAfterTime, dir.UpdatedAfter)
+	opts.Filter.UpdatedBefore = fromPbTime(dir.UpdatedBeforeTime, dir.UpdatedBefore)
+	return opts
+}
+
+// fromPbTime 舊版 client 只送出 unix 秒數，兩者都沒有設定時回傳零值
+func fromPbTime(t *timestamppb.Timestamp, unix int64) time.Time {
+	if t != nil {
+		return t.AsTime()
+	}
+	if unix != 0 {
+		return time.Unix(unix, 0)
+	}
+	return time.Time{}
+}
+
+func toPbObjectInfo(o *storage.ObjectInfo) *pb.ObjectInfo {
+	return &pb.ObjectInfo{
+		Key:         o.Key,
+		Size:        o.Size,
+		Updated:     o.Updated.Unix(),
+		UpdatedTime: timestamppb.New(o.Updated),
+		Generation:  o.Generation,
+	}
+}
+
+// 刪除目錄下所有的物件
+func (gcp *gcp) DeletePrefix(ctx context.Context, dir *pb.Dir) (*emptypb.Empty, error) {
+	sto, err := getCapability[storage.PrefixDeleter](ctx, gcp)
+	if err != nil {
+		return nil, err
+	}
+	err = sto.DeletePrefix(dir.Path)
+	if err != nil {
+		return nil, toStatusError(codes.Internal, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// 列出物件的所有版本
+func (gcp *gcp) ListVersions(ctx context.Context, key *pb.ObjectKey) (*pb.VersionList, error) {
+	sto, err := getCapability[storage.VersionedStorage](ctx, gcp)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := sto.ListVersions(key.Key)
+	if err != nil {
+		return nil, toStatusError(codes.Internal, err)
+	}
+	response := &pb.VersionList{Versions: make([]*pb.ObjectVersion, len(versions))}
+	for i, v := range versions {
+		response.Versions[i] = &pb.ObjectVersion{
+			Key:         v.Key,
+			Generation:  v.Generation,
+			Size:        v.Size,
+			Updated:     v.Updated.Unix(),
+			UpdatedTime: timestamppb.New(v.Updated),
+			IsLatest:    v.IsLatest,
+		}
+	}
+	return response, nil
+}
+
+// 取得指定版本的檔案
+func (gcp *gcp) GetVersion(ctx context.Context, key *pb.ObjectKey) (*pb.File, error) {
+	sto, err := getCapability[storage.VersionedStorage](ctx, gcp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := sto.GetVersion(key.Key, key.Generation)
+	if err != nil {
+		code := codes.Internal
+		if isNotExist(err) {
+			code = codes.NotFound
+		}
+		return nil, toStatusError(code, err)
+	}
+	return &pb.File{File: data}, nil
+}
+
+// 將指定版本還原為目前版本
+func (gcp *gcp) RestoreVersion(ctx context.Context, key *pb.ObjectKey) (*pb.Url, error) {
+	sto, err := getCapability[storage.VersionedStorage](ctx, gcp)
+	if err != nil {
+		return nil, err
+	}
+	path, err := sto.RestoreVersion(key.Key, key.Generation)
+	if err != nil {
+		return nil, toStatusError(codes.Internal, err)
+	}
+	return &pb.Url{Url: path}, nil
+}
+
+// 刪除指定版本
+func (gcp *gcp) DeleteVersion(ctx context.Context, key *pb.ObjectKey) (*emptypb.Empty, error) {
+	sto, err := getCapability[storage.VersionedStorage](ctx, gcp)
+	if err != nil {
+		return nil, err
+	}
+	err = sto.DeleteVersion(key.Key, key.Generation)
+	if err != nil {
+		return nil, toStatusError(codes.Internal, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// 單一批次請求的 key 數量上限
+const _batch_MaxKeys = 1000
+
+// 批次刪除
+func (gcp *gcp) BatchDelete(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	return gcp.batch(ctx, req, storage.BatchStorage.BatchDelete)
+}
+
+// 批次檢查檔案是否存在
+func (gcp *gcp) BatchExist(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	return gcp.batch(ctx, req, storage.BatchStorage.BatchExist)
+}
+
+// 批次取得物件資訊
+func (gcp *gcp) BatchStat(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	return gcp.batch(ctx, req, storage.BatchStorage.BatchStat)
+}
+
+// batch 整批只建立一次 storage，個別 key 的錯誤轉換為與單一 key 的 rpc 相同的 status
+func (gcp *gcp) batch(ctx context.Context, req *pb.BatchRequest, run func(storage.BatchStorage, []string) ([]storage.BatchResult, error)) (*pb.BatchResponse, error) {
+	if len(req.Keys) > _batch_MaxKeys {
+		return nil, status.Errorf(codes.InvalidArgument, "too many keys: %d > %d", len(req.Keys), _batch_MaxKeys)
+	}
+	sto, err := getCapability[storage.BatchStorage](ctx, gcp)
+	if err != nil {
+		return nil, err
+	}
+	results, err := run(sto, req.Keys)
+	if err != nil {
+		return nil, toStatusError(codes.Internal, err)
+	}
+	response := &pb.BatchResponse{Results: make([]*pb.BatchResult, len(results))}
+	for i, r := range results {
+		result := &pb.BatchResult{Key: r.Key, Exist: r.Exist}
+		if r.Object != nil {
+			result.Object = toPbObjectInfo(r.Object)
+		}
+		if r.Err != nil {
+			code, reason := storageErrorCode(codes.Internal, r.Err)
+			result.ErrorCode, result.ErrorMessage, result.ErrorReason = int32(code), r.Err.Error(), reason
+		}
+		response.Results[i] = result
+	}
+	return response, nil
 }
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/94peter/storage"
+	"github.com/94peter/storage/grpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newGcpClient(t *testing.T, cfg *storage.Config) pb.GcpServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterGcpServiceServer(server, NewGcp(cfg))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewGcpServiceClient(conn)
+}
+
+func TestGetVersionError(t *testing.T) {
+	cfg := &storage.Config{
+		Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{
+			"test": {Type: storage.ChannelMemory},
+		}),
+	}
+	client := newGcpClient(t, cfg)
+	ctx := authContext("test")
+
+	_, err := client.GetVersion(ctx, &pb.ObjectKey{Key: "a.txt", Generation: 1})
+	expectCode(t, err, codes.NotFound)
+
+	sto, err := cfg.Channels.NewStorage(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sto.(storage.MemStorage).FailOn("GetVersion", 0, errors.New("backend unavailable"))
+	_, err = client.GetVersion(ctx, &pb.ObjectKey{Key: "a.txt", Generation: 1})
+	expectCode(t, err, codes.Internal)
+}
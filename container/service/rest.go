@@ -0,0 +1,370 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/94peter/log"
+	"github.com/94peter/storage"
+)
+
+// NewRest 提供 http 存取檔案，路徑的第一層為 channel：
+//
+//	PUT/GET/HEAD/DELETE /{channel}/{key}
+//	GET /{channel}?prefix=&delimiter=&recursive=&pageSize=&pageToken=
+//
+// ETag 為物件的 generation，PUT 及 DELETE 可用 If-Match 及 If-None-Match: * 作為前置條件
+func NewRest(cfg *storage.Config) http.Handler {
+	return &restHandler{
+		channels: cfg.Channels,
+		log:      cfg.Log,
+	}
+}
+
+type restHandler struct {
+	channels storage.ChannelRegistry
+	log      log.Logger
+}
+
+type restObject struct {
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	Updated    time.Time `json:"updated"`
+	Generation int64     `json:"generation,omitempty"`
+}
+
+type restListResponse struct {
+	Objects       []restObject `json:"objects"`
+	Prefixes      []string     `json:"prefixes,omitempty"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+}
+
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	channel, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if channel == "" || h.channels.GetChannel(channel) == nil {
+		http.Error(w, fmt.Sprintf("channel not found [%s]", channel), http.StatusNotFound)
+		return
+	}
+	sto, err := h.channels.NewStorage(r.Context(), channel)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		err = h.list(w, r, sto)
+	case key == "":
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		err = serveContent(w, r, sto, key)
+	case r.Method == http.MethodPut:
+		err = h.put(w, r, sto, key)
+	case r.Method == http.MethodDelete:
+		err = h.delete(w, r, sto, key)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+	if err != nil {
+		h.writeError(w, r, err)
+	}
+}
+
+var (
+	errObjectNotFound   = errors.New("object not found")
+	errConditionalNotOk = errors.New("channel does not support conditional requests")
+	errInvalidETag      = errors.New("etag is not a generation")
+)
+
+func (h *restHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errObjectNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalidKey), errors.Is(err, errInvalidETag):
+		code = http.StatusBadRequest
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		code = http.StatusPreconditionFailed
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		code = http.StatusInsufficientStorage
+	case errors.Is(err, errConditionalNotOk):
+		code = http.StatusNotImplemented
+	}
+	if code == http.StatusInternalServerError {
+		h.log.Errorf("rest %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// statObject 優先以 BatchStat 取得單一物件的資訊，否則以列表範圍查詢；
+// 兩者都不支援的後端只能得知是否存在，Size 為 -1
+func statObject(sto storage.Storage, key string) (*storage.ObjectInfo, error) {
+	if batcher, ok := sto.(storage.BatchStorage); ok {
+		results, err := batcher.BatchStat([]string{key})
+		if err != nil {
+			return nil, err
+		}
+		if len(results) == 1 {
+			if results[0].Err != nil {
+				return nil, results[0].Err
+			}
+			if results[0].Object == nil {
+				return nil, errObjectNotFound
+			}
+			return results[0].Object, nil
+		}
+	}
+	if lister, ok := sto.(storage.ObjectLister); ok {
+		page, err := lister.ListObjects(key, &storage.ListOptions{
+			Recursive:   true,
+			StartOffset: key,
+			EndOffset:   key + "\x00",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Objects {
+			if page.Objects[i].Key == key {
+				return &page.Objects[i], nil
+			}
+		}
+		return nil, errObjectNotFound
+	}
+	exist, err := sto.FileExist(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, errObjectNotFound
+	}
+	return &storage.ObjectInfo{Key: key, Size: -1}, nil
+}
+
+func etag(o *storage.ObjectInfo) string {
+	if o.Generation != 0 {
+		return fmt.Sprintf(`"%d"`, o.Generation)
+	}
+	if !o.Updated.IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, o.Updated.UnixNano(), o.Size)
+	}
+	return ""
+}
+
+func parseGeneration(etag string) (int64, error) {
+	generation, err := strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errInvalidETag, etag)
+	}
+	return generation, nil
+}
+
+// serveContent 回傳物件內容，Range、If-None-Match 及 If-Modified-Since 由 http.ServeContent 處理
+func serveContent(w http.ResponseWriter, r *http.Request, sto storage.Storage, key string) error {
+	info, err := statObject(sto, key)
+	if err != nil {
+		return err
+	}
+	var content io.ReadSeeker
+	if reader, ok := sto.(storage.ObjectReader); ok && info.Size >= 0 {
+		rs := &rangeSeeker{reader: reader, key: key, size: info.Size}
+		defer rs.Close()
+		content = rs
+	} else {
+		data, err := sto.Get(key)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+	if tag := etag(info); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	http.ServeContent(w, r, path.Base(key), info.Updated, content)
+	return nil
+}
+
+// put 有前置條件時需將內容讀入記憶體，否則以串流寫入
+func (h *restHandler) put(w http.ResponseWriter, r *http.Request, sto storage.Storage, key string) error {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		if _, err := sto.SaveByReader(key, r.Body); err != nil {
+			return err
+		}
+	} else {
+		conditional, ok := sto.(storage.ConditionalStorage)
+		if !ok {
+			return errConditionalNotOk
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ifNoneMatch == "*":
+			_, err = conditional.SaveIfNotExists(key, data)
+		case ifNoneMatch != "":
+			return fmt.Errorf("%w: If-None-Match only supports *", errInvalidETag)
+		default:
+			var generation int64
+			if generation, err = parseGeneration(ifMatch); err != nil {
+				return err
+			}
+			_, err = conditional.SaveIfMatch(key, data, generation)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := statObject(sto, key)
+	if err != nil {
+		return err
+	}
+	if tag := etag(info); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(restObject{
+		Key:        info.Key,
+		Size:       info.Size,
+		Updated:    info.Updated,
+		Generation: info.Generation,
+	})
+}
+
+func (h *restHandler) delete(w http.ResponseWriter, r *http.Request, sto storage.Storage, key string) error {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		conditional, ok := sto.(storage.ConditionalStorage)
+		if !ok {
+			return errConditionalNotOk
+		}
+		generation, err := parseGeneration(ifMatch)
+		if err != nil {
+			return err
+		}
+		if err = conditional.DeleteIfMatch(key, generation); err != nil {
+			return err
+		}
+	} else {
+		if _, err := statObject(sto, key); err != nil {
+			return err
+		}
+		if err := sto.Delete(key); err != nil {
+			return err
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *restHandler) list(w http.ResponseWriter, r *http.Request, sto storage.Storage) error {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	response := restListResponse{Objects: []restObject{}}
+	if lister, ok := sto.(storage.ObjectLister); ok {
+		pageSize := 0
+		if s := query.Get("pageSize"); s != "" {
+			var err error
+			if pageSize, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "invalid pageSize", http.StatusBadRequest)
+				return nil
+			}
+		}
+		page, err := lister.ListObjects(prefix, &storage.ListOptions{
+			Delimiter:   query.Get("delimiter"),
+			Recursive:   query.Get("recursive") == "true",
+			PageSize:    pageSize,
+			PageToken:   query.Get("pageToken"),
+			StartOffset: query.Get("startOffset"),
+			EndOffset:   query.Get("endOffset"),
+			MatchGlob:   query.Get("matchGlob"),
+		})
+		if err != nil {
+			return err
+		}
+		for _, o := range page.Objects {
+			response.Objects = append(response.Objects, restObject{
+				Key:        o.Key,
+				Size:       o.Size,
+				Updated:    o.Updated,
+				Generation: o.Generation,
+			})
+		}
+		response.Prefixes = page.Prefixes
+		response.NextPageToken = page.NextToken
+	} else {
+		keys, err := sto.List(prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if strings.HasSuffix(key, "/") {
+				response.Prefixes = append(response.Prefixes, key)
+			} else {
+				response.Objects = append(response.Objects, restObject{Key: key, Size: -1})
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
+
+// rangeSeeker 讓 http.ServeContent 以串流處理 Range，Seek 只記錄位置，Read 時才開啟對應位置的 reader
+type rangeSeeker struct {
+	reader storage.ObjectReader
+	key    string
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+}
+
+func (r *rangeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to negative position")
+	}
+	if offset != r.offset {
+		r.Close()
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *rangeSeeker) Read(p []byte) (int, error) {
+	if r.rc == nil {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		rc, err := r.reader.NewRangeReader(r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeSeeker) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/94peter/storage"
+)
+
+func newRestServer(t *testing.T, channel *storage.ChannelConf) *httptest.Server {
+	server := httptest.NewServer(NewRest(&storage.Config{
+		Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{"test": channel}),
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRest(t *testing.T) {
+	channels := map[string]*storage.ChannelConf{
+		"Hd":     {Type: storage.ChannelHd, Hd: &storage.HdConf{Path: t.TempDir()}},
+		"Memory": {Type: storage.ChannelMemory},
+	}
+	for name, channel := range channels {
+		t.Run(name, func(t *testing.T) {
+			testRest(t, newRestServer(t, channel))
+		})
+	}
+}
+
+func testRest(t *testing.T, server *httptest.Server) {
+	do := func(method, path, body string, header map[string]string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(data)
+	}
+	expect := func(resp *http.Response, code int) {
+		t.Helper()
+		if resp.StatusCode != code {
+			t.Fatalf("%s %s = %d, want %d", resp.Request.Method, resp.Request.URL, resp.StatusCode, code)
+		}
+	}
+
+	resp, _ := do("PUT", "/test/dir/a.txt", "0123456789", nil)
+	expect(resp, http.StatusOK)
+	tag := resp.Header.Get("ETag")
+	if tag == "" {
+		t.Fatal("PUT: missing ETag")
+	}
+
+	resp, body := do("GET", "/test/dir/a.txt", "", nil)
+	expect(resp, http.StatusOK)
+	if body != "0123456789" || resp.Header.Get("ETag") != tag {
+		t.Fatalf("GET = %q, etag %q", body, resp.Header.Get("ETag"))
+	}
+	resp, _ = do("HEAD", "/test/dir/a.txt", "", nil)
+	expect(resp, http.StatusOK)
+	if resp.ContentLength != 10 {
+		t.Fatalf("HEAD Content-Length = %d", resp.ContentLength)
+	}
+	resp, body = do("GET", "/test/dir/a.txt", "", map[string]string{"Range": "bytes=2-5"})
+	expect(resp, http.StatusPartialContent)
+	if body != "2345" {
+		t.Fatalf("GET range = %q", body)
+	}
+	resp, _ = do("GET", "/test/dir/a.txt", "", map[string]string{"If-None-Match": tag})
+	expect(resp, http.StatusNotModified)
+
+	resp, _ = do("PUT", "/test/dir/a.txt", "new", map[string]string{"If-None-Match": "*"})
+	expect(resp, http.StatusPreconditionFailed)
+	resp, _ = do("PUT", "/test/dir/a.txt", "new", map[string]string{"If-Match": tag})
+	expect(resp, http.StatusOK)
+	resp, _ = do("DELETE", "/test/dir/a.txt", "", map[string]string{"If-Match": tag})
+	expect(resp, http.StatusPreconditionFailed)
+
+	do("PUT", "/test/dir/sub/b.txt", "b", nil)
+	resp, body = do("GET", "/test?prefix=dir/", "", nil)
+	expect(resp, http.StatusOK)
+	var list restListResponse
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatalf("list: %v, %s", err, body)
+	}
+	if len(list.Objects) != 1 || list.Objects[0].Key != "dir/a.txt" || list.Objects[0].Size != 3 ||
+		len(list.Prefixes) != 1 || list.Prefixes[0] != "dir/sub/" {
+		t.Fatalf("list = %s", body)
+	}
+
+	resp, _ = do("DELETE", "/test/dir/a.txt", "", nil)
+	expect(resp, http.StatusNoContent)
+	resp, _ = do("GET", "/test/dir/a.txt", "", nil)
+	expect(resp, http.StatusNotFound)
+	resp, _ = do("DELETE", "/test/dir/a.txt", "", nil)
+	expect(resp, http.StatusNotFound)
+	resp, _ = do("GET", "/missing/a.txt", "", nil)
+	expect(resp, http.StatusNotFound)
+	resp, _ = do("GET", "/test/../a.txt", "", nil)
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("GET with .. in key: want error")
+	}
+}
+
+// listerStorage 只實作 storage.ObjectLister，測試不支援 BatchStorage 時改以列表查詢
+type listerStorage struct {
+	storage.Storage
+	storage.ObjectLister
+}
+
+// TestStatObject 支援 BatchStorage 時以 BatchStat 取得物件資訊，不需列表
+func TestStatObject(t *testing.T) {
+	mem := storage.NewMemStorage()
+	if _, err := mem.Save("dir/a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Save("dir/a.txt2", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	for name, sto := range map[string]storage.Storage{
+		"BatchStat": mem,
+		"List":      listerStorage{Storage: mem, ObjectLister: mem},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mem.ResetFaults()
+			info, err := statObject(sto, "dir/a.txt")
+			if err != nil || info.Key != "dir/a.txt" || info.Size != 5 || info.Generation == 0 {
+				t.Fatalf("statObject = %+v, %v", info, err)
+			}
+			if _, err = statObject(sto, "dir/missing.txt"); !errors.Is(err, errObjectNotFound) {
+				t.Fatalf("statObject missing = %v", err)
+			}
+			batch, list := mem.Calls("BatchStat"), mem.Calls("ListObjects")
+			if name == "BatchStat" && (batch != 2 || list != 0) || name == "List" && (batch != 0 || list != 2) {
+				t.Fatalf("calls = %d BatchStat, %d ListObjects", batch, list)
+			}
+		})
+	}
+}
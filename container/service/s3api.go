@@ -0,0 +1,572 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/94peter/log"
+	"github.com/94peter/storage"
+)
+
+const (
+	_s3_TimeFormat   = "2006-01-02T15:04:05.000Z"
+	_s3_MaxKeys      = 1000
+	_s3_MaxDeleteXML = 2 << 20
+
+	_s3_UploadTTL        = 24 * time.Hour
+	_s3_MaxUploadsPerKey = 100
+)
+
+// s3 的子資源，未列在其中的參數不影響路由；這些功能都不支援
+var s3UnsupportedSubresources = []string{
+	"acl", "policy", "cors", "lifecycle", "versioning", "versions", "tagging",
+	"encryption", "object-lock", "website", "replication", "notification", "logging",
+}
+
+// NewS3Api 提供相容 S3 的 API，bucket 即為 channel，只支援 path-style 的網址：
+//
+//	GET / 列出可存取的 channel
+//	GET/HEAD/PUT/DELETE /{channel}/{key}，含分段上傳
+//	GET /{channel}?list-type=2 及 POST /{channel}?delete
+//
+// 只有設定 s3ApiKeys 的 channel 會開放，請求需以其中的金鑰做 SigV4 簽章
+func NewS3Api(cfg *storage.Config) (S3Api, error) {
+	return NewS3ApiWithOptions(cfg, S3ApiOptions{})
+}
+
+// S3Api 結束時需呼叫 Close 移除未完成分段上傳的暫存檔
+type S3Api interface {
+	http.Handler
+	io.Closer
+}
+
+// S3ApiOptions 設定分段上傳的暫存，零值使用預設值
+type S3ApiOptions struct {
+	// UploadDir 為分段上傳的暫存目錄，啟動時會清空；未設定時於系統暫存目錄下建立，Close 時移除
+	UploadDir string
+	// UploadTTL 為分段上傳最後一次上傳後保留的時間，預設 24 小時
+	UploadTTL time.Duration
+	// MaxUploadsPerKey 為每個 access key 同時進行的分段上傳數，預設 100
+	MaxUploadsPerKey int
+}
+
+func NewS3ApiWithOptions(cfg *storage.Config, opts S3ApiOptions) (S3Api, error) {
+	if opts.UploadTTL <= 0 {
+		opts.UploadTTL = _s3_UploadTTL
+	}
+	if opts.MaxUploadsPerKey <= 0 {
+		opts.MaxUploadsPerKey = _s3_MaxUploadsPerKey
+	}
+	uploadDir, removeDir, err := initUploadDir(opts.UploadDir)
+	if err != nil {
+		return nil, err
+	}
+	h := &s3ApiHandler{
+		channels:         cfg.Channels,
+		log:              cfg.Log,
+		secrets:          map[string]string{},
+		grants:           map[string]map[string]bool{},
+		uploadDir:        uploadDir,
+		removeUploadDir:  removeDir,
+		uploadTTL:        opts.UploadTTL,
+		maxUploadsPerKey: opts.MaxUploadsPerKey,
+		uploads:          map[string]*s3Upload{},
+		created:          time.Now(),
+		done:             make(chan struct{}),
+	}
+	for _, name := range cfg.Channels.Names() {
+		for _, key := range cfg.Channels.GetChannel(name).S3ApiKeys {
+			if secret, ok := h.secrets[key.AccessKey]; ok && secret != key.SecretKey {
+				// 同一個 access key 只能有一個 secret，以先設定的為準
+				h.log.Warnf("s3 api: access key [%s] of channel [%s] has a different secret, ignored", key.AccessKey, name)
+				continue
+			}
+			h.secrets[key.AccessKey] = key.SecretKey
+			if h.grants[key.AccessKey] == nil {
+				h.grants[key.AccessKey] = map[string]bool{}
+			}
+			h.grants[key.AccessKey][name] = true
+		}
+	}
+	go h.sweepUploads()
+	return h, nil
+}
+
+type s3ApiHandler struct {
+	channels storage.ChannelRegistry
+	log      log.Logger
+	// accessKey -> secretKey
+	secrets map[string]string
+	// accessKey -> 可存取的 channel
+	grants  map[string]map[string]bool
+	created time.Time
+
+	uploadDir        string
+	removeUploadDir  bool
+	uploadTTL        time.Duration
+	maxUploadsPerKey int
+
+	mu      sync.Mutex
+	uploads map[string]*s3Upload
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type s3Error struct {
+	status  int
+	Code    string
+	Message string
+}
+
+func newS3Error(status int, code, message string) *s3Error {
+	return &s3Error{status: status, Code: code, Message: message}
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var errS3NotImplemented = newS3Error(http.StatusNotImplemented, "NotImplemented", "a header or query you provided implies functionality that is not implemented")
+
+type s3ErrorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3LocationConstraint struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string `xml:",omitempty"`
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3ListBucketResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name         string
+	Prefix       string
+	Delimiter    string `xml:",omitempty"`
+	MaxKeys      int
+	EncodingType string `xml:",omitempty"`
+	IsTruncated  bool
+	// ListObjects v1
+	Marker     *string `xml:",omitempty"`
+	NextMarker string  `xml:",omitempty"`
+	// ListObjectsV2
+	KeyCount              *int   `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+
+	Contents       []s3Object
+	CommonPrefixes []s3CommonPrefix
+}
+
+type s3DeleteRequest struct {
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type s3Deleted struct {
+	Key string
+}
+
+type s3DeleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+type s3DeleteResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []s3Deleted     `xml:"Deleted"`
+	Errors  []s3DeleteError `xml:"Error"`
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	ETag         string
+	LastModified string
+}
+
+func (h *s3ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			h.writeError(w, r, errS3NotImplemented)
+			return
+		}
+		h.listBuckets(w, auth)
+		return
+	}
+	if !h.grants[auth.accessKey][bucket] {
+		if h.channels.GetChannel(bucket) == nil {
+			err = newS3Error(http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		} else {
+			err = newS3Error(http.StatusForbidden, "AccessDenied", "access denied")
+		}
+		h.writeError(w, r, err)
+		return
+	}
+	query := r.URL.Query()
+	for _, sub := range s3UnsupportedSubresources {
+		if query.Has(sub) {
+			h.writeError(w, r, errS3NotImplemented)
+			return
+		}
+	}
+	sto, err := h.channels.NewStorage(r.Context(), bucket)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if key == "" {
+		err = h.serveBucket(w, r, auth, sto, bucket, query)
+	} else {
+		err = h.serveObject(w, r, auth, sto, bucket, key, query)
+	}
+	if err != nil {
+		h.writeError(w, r, err)
+	}
+}
+
+func (h *s3ApiHandler) serveBucket(w http.ResponseWriter, r *http.Request, auth *s3Auth, sto storage.Storage, bucket string, query url.Values) error {
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			writeXML(w, http.StatusOK, s3LocationConstraint{})
+			return nil
+		case query.Has("uploads"):
+			return errS3NotImplemented
+		}
+		return h.listObjects(w, sto, bucket, query)
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodPut:
+		// channel 由設定檔建立，bucket 已存在時 CreateBucket 視為成功
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodPost:
+		if query.Has("delete") {
+			return h.deleteObjects(w, r, auth, sto)
+		}
+	}
+	return errS3NotImplemented
+}
+
+func (h *s3ApiHandler) serveObject(w http.ResponseWriter, r *http.Request, auth *s3Auth, sto storage.Storage, bucket, key string, query url.Values) error {
+	uploadID := query.Get("uploadId")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if uploadID != "" {
+			return errS3NotImplemented
+		}
+		return serveContent(w, r, sto, key)
+	case http.MethodPut:
+		switch {
+		case uploadID != "":
+			return h.uploadPart(w, r, auth, bucket, key, query)
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			return h.copyObject(w, r, auth, sto, key)
+		}
+		return h.putObject(w, r, auth, sto, key)
+	case http.MethodDelete:
+		if uploadID != "" {
+			return h.abortMultipartUpload(w, auth, bucket, key, uploadID)
+		}
+		if err := deleteKey(sto, key); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			return h.createMultipartUpload(w, auth, bucket, key)
+		case uploadID != "":
+			return h.completeMultipartUpload(w, r, auth, sto, bucket, key, uploadID)
+		}
+	}
+	return errS3NotImplemented
+}
+
+func (h *s3ApiHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var s3Err *s3Error
+	if !errors.As(err, &s3Err) {
+		switch {
+		case errors.Is(err, errObjectNotFound):
+			s3Err = newS3Error(http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		case errors.Is(err, storage.ErrInvalidKey):
+			s3Err = newS3Error(http.StatusBadRequest, "InvalidArgument", err.Error())
+		case errors.Is(err, storage.ErrPreconditionFailed):
+			s3Err = newS3Error(http.StatusPreconditionFailed, "PreconditionFailed", err.Error())
+		case errors.Is(err, storage.ErrQuotaExceeded):
+			s3Err = newS3Error(http.StatusForbidden, "QuotaExceeded", err.Error())
+		default:
+			h.log.Errorf("s3 api %s %s: %v", r.Method, r.URL.Path, err)
+			s3Err = newS3Error(http.StatusInternalServerError, "InternalError", "we encountered an internal error, please try again")
+		}
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(s3Err.status)
+		return
+	}
+	writeXML(w, s3Err.status, s3ErrorResponse{
+		Code:     s3Err.Code,
+		Message:  s3Err.Message,
+		Resource: r.URL.Path,
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (h *s3ApiHandler) listBuckets(w http.ResponseWriter, auth *s3Auth) {
+	result := s3ListAllMyBucketsResult{Owner: s3Owner{ID: auth.accessKey, DisplayName: auth.accessKey}}
+	for _, name := range h.channels.Names() {
+		if h.grants[auth.accessKey][name] {
+			result.Buckets = append(result.Buckets, s3Bucket{Name: name, CreationDate: h.created.UTC().Format(_s3_TimeFormat)})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// listObjects 同時處理 ListObjects 及 ListObjectsV2，continuation-token 即為後端的 page token
+func (h *s3ApiHandler) listObjects(w http.ResponseWriter, sto storage.Storage, bucket string, query url.Values) error {
+	lister, ok := sto.(storage.ObjectLister)
+	if !ok {
+		return newS3Error(http.StatusNotImplemented, "NotImplemented", "the channel does not support listing objects")
+	}
+	v2 := query.Get("list-type") == "2"
+	maxKeys := _s3_MaxKeys
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+		}
+		maxKeys = min(n, _s3_MaxKeys)
+	}
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		return newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid encoding-type")
+	}
+	encode := func(s string) string {
+		if encodingType == "url" {
+			return url.QueryEscape(s)
+		}
+		return s
+	}
+
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	result := s3ListBucketResult{
+		Name:         bucket,
+		Prefix:       encode(prefix),
+		Delimiter:    encode(delimiter),
+		MaxKeys:      maxKeys,
+		EncodingType: encodingType,
+	}
+	opts := &storage.ListOptions{Delimiter: delimiter, Recursive: delimiter == "", PageSize: maxKeys}
+	// StartOffset 包含本身，加上 \x00 表示從 after 之後開始
+	var after string
+	if v2 {
+		result.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter = encode(query.Get("start-after"))
+		opts.PageToken = result.ContinuationToken
+		if opts.PageToken == "" {
+			after = query.Get("start-after")
+		}
+	} else {
+		after = query.Get("marker")
+		marker := encode(after)
+		result.Marker = &marker
+	}
+	if after != "" {
+		opts.StartOffset = after + "\x00"
+	}
+
+	page := &storage.ListPage{}
+	if maxKeys > 0 {
+		var err error
+		if page, err = lister.ListObjects(prefix, opts); err != nil {
+			return err
+		}
+	}
+	var last string
+	for i := range page.Objects {
+		o := &page.Objects[i]
+		result.Contents = append(result.Contents, s3Object{
+			Key:          encode(o.Key),
+			LastModified: o.Updated.UTC().Format(_s3_TimeFormat),
+			ETag:         etag(o),
+			Size:         o.Size,
+			StorageClass: "STANDARD",
+		})
+		last = max(last, o.Key)
+	}
+	for _, p := range page.Prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: encode(p)})
+		last = max(last, p)
+	}
+	result.IsTruncated = page.NextToken != ""
+	if v2 {
+		keyCount := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &keyCount
+		result.NextContinuationToken = page.NextToken
+	} else if result.IsTruncated {
+		result.NextMarker = encode(last)
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+func (h *s3ApiHandler) putObject(w http.ResponseWriter, r *http.Request, auth *s3Auth, sto storage.Storage, key string) error {
+	body, err := auth.body(r)
+	if err != nil {
+		return err
+	}
+	if _, err = sto.SaveByReader(key, body); err != nil {
+		return body.wrap(err)
+	}
+	info, err := statObject(sto, key)
+	if err != nil {
+		return err
+	}
+	if tag := etag(info); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// copyObject 來源也必須是同一把金鑰可存取的 channel
+func (h *s3ApiHandler) copyObject(w http.ResponseWriter, r *http.Request, auth *s3Auth, sto storage.Storage, key string) error {
+	source, _, _ := strings.Cut(r.Header.Get("X-Amz-Copy-Source"), "?")
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid x-amz-copy-source")
+	}
+	srcBucket, srcKey, _ := strings.Cut(source, "/")
+	if !h.grants[auth.accessKey][srcBucket] {
+		return newS3Error(http.StatusForbidden, "AccessDenied", "access denied to the copy source")
+	}
+	src, err := h.channels.NewStorage(r.Context(), srcBucket)
+	if err != nil {
+		return err
+	}
+	if _, err = statObject(src, srcKey); err != nil {
+		return err
+	}
+	// 來源不支援 ObjectReader 時才將整個物件讀入記憶體
+	var content io.Reader
+	if reader, ok := src.(storage.ObjectReader); ok {
+		rc, err := reader.NewRangeReader(srcKey, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		content = rc
+	} else {
+		data, err := src.Get(srcKey)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+	if _, err = sto.SaveByReader(key, content); err != nil {
+		return err
+	}
+	info, err := statObject(sto, key)
+	if err != nil {
+		return err
+	}
+	writeXML(w, http.StatusOK, s3CopyObjectResult{
+		ETag:         etag(info),
+		LastModified: info.Updated.UTC().Format(_s3_TimeFormat),
+	})
+	return nil
+}
+
+// deleteKey key 不存在時與 S3 相同視為成功
+func deleteKey(sto storage.Storage, key string) error {
+	exist, err := sto.FileExist(key)
+	if err != nil || !exist {
+		return err
+	}
+	return sto.Delete(key)
+}
+
+func (h *s3ApiHandler) deleteObjects(w http.ResponseWriter, r *http.Request, auth *s3Auth, sto storage.Storage) error {
+	body, err := auth.body(r)
+	if err != nil {
+		return err
+	}
+	var req s3DeleteRequest
+	if err = body.readXML(&req); err != nil {
+		return err
+	}
+	if len(req.Objects) > _s3_MaxKeys {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", fmt.Sprintf("at most %d keys can be deleted in one request", _s3_MaxKeys))
+	}
+	var result s3DeleteResult
+	for _, o := range req.Objects {
+		if err = deleteKey(sto, o.Key); err != nil {
+			result.Errors = append(result.Errors, s3DeleteError{Key: o.Key, Code: "InternalError", Message: err.Error()})
+			continue
+		}
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, s3Deleted{Key: o.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
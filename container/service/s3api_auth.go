@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_s3_Algorithm       = "AWS4-HMAC-SHA256"
+	_s3_AmzDateFormat   = "20060102T150405Z"
+	_s3_MaxClockSkew    = 15 * time.Minute
+	_s3_MaxChunkSize    = 64 << 20
+	_s3_UnsignedPayload = "UNSIGNED-PAYLOAD"
+	_s3_StreamingSigned = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// aws sdk 附加 checksum trailer 時使用，trailer 不檢查
+	_s3_StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// s3Auth 為驗證通過的請求，分段上傳的內容以 signingKey 驗證每一段的簽章
+type s3Auth struct {
+	accessKey   string
+	signingKey  []byte
+	amzDate     string
+	scope       string
+	signature   string
+	payloadHash string
+}
+
+// authenticate 驗證 Authorization header 或 presigned url 的 SigV4 簽章
+func (h *s3ApiHandler) authenticate(r *http.Request) (*s3Auth, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return h.authenticatePresigned(r, query)
+	}
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, newS3Error(http.StatusForbidden, "AccessDenied", "anonymous access is not allowed")
+	}
+	if !strings.HasPrefix(authorization, _s3_Algorithm+" ") {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "only AWS4-HMAC-SHA256 is supported")
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, _s3_Algorithm+" "), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[k] = v
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return nil, newS3Error(http.StatusForbidden, "AccessDenied", "missing X-Amz-Date")
+		}
+		amzDate = date.UTC().Format(_s3_AmzDateFormat)
+	}
+	t, err := time.Parse(_s3_AmzDateFormat, amzDate)
+	if err != nil {
+		return nil, newS3Error(http.StatusForbidden, "AccessDenied", "invalid X-Amz-Date")
+	}
+	if d := time.Since(t); d > _s3_MaxClockSkew || d < -_s3_MaxClockSkew {
+		return nil, newS3Error(http.StatusForbidden, "RequestTimeTooSkewed", "the difference between the request time and the server's time is too large")
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = emptySHA256
+	}
+	return h.verify(r, query, fields["Credential"], fields["SignedHeaders"], fields["Signature"], amzDate, payloadHash)
+}
+
+func (h *s3ApiHandler) authenticatePresigned(r *http.Request, query map[string][]string) (*s3Auth, error) {
+	get := func(k string) string {
+		if v := query[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	if get("X-Amz-Algorithm") != _s3_Algorithm {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationQueryParametersError", "only AWS4-HMAC-SHA256 is supported")
+	}
+	amzDate := get("X-Amz-Date")
+	t, err := time.Parse(_s3_AmzDateFormat, amzDate)
+	if err != nil {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationQueryParametersError", "invalid X-Amz-Date")
+	}
+	expires, err := strconv.Atoi(get("X-Amz-Expires"))
+	if err != nil || expires < 0 {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationQueryParametersError", "invalid X-Amz-Expires")
+	}
+	if time.Now().After(t.Add(time.Duration(expires) * time.Second)) {
+		return nil, newS3Error(http.StatusForbidden, "AccessDenied", "request has expired")
+	}
+	return h.verify(r, query, get("X-Amz-Credential"), get("X-Amz-SignedHeaders"), get("X-Amz-Signature"), amzDate, _s3_UnsignedPayload)
+}
+
+func (h *s3ApiHandler) verify(r *http.Request, query map[string][]string, credential, signedHeaders, signature, amzDate, payloadHash string) (*s3Auth, error) {
+	// credential 格式為 accessKey/yyyymmdd/region/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "malformed credential")
+	}
+	accessKey := parts[0]
+	secretKey, ok := h.secrets[accessKey]
+	if !ok {
+		return nil, newS3Error(http.StatusForbidden, "InvalidAccessKeyId", "the access key does not exist")
+	}
+	if !strings.HasPrefix(amzDate, parts[1]) {
+		return nil, newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "credential date does not match X-Amz-Date")
+	}
+	scope := strings.Join(parts[1:], "/")
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		awsEscape(r.URL.Path, false),
+		canonicalQuery(query),
+		canonicalHeaders(r, signedHeaders),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{_s3_Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := []byte("AWS4" + secretKey)
+	for _, p := range parts[1:] {
+		signingKey = hmacSHA256(signingKey, p)
+	}
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return nil, newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "the request signature we calculated does not match the signature you provided")
+	}
+	return &s3Auth{
+		accessKey:   accessKey,
+		signingKey:  signingKey,
+		amzDate:     amzDate,
+		scope:       scope,
+		signature:   signature,
+		payloadHash: payloadHash,
+	}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// awsEscape 依 SigV4 的規則編碼，只保留 RFC 3986 的 unreserved 字元
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
+
+func canonicalQuery(query map[string][]string) string {
+	var params []string
+	for k, values := range query {
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range values {
+			params = append(params, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func canonicalHeaders(r *http.Request, signedHeaders string) string {
+	var b strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		default:
+			values = append([]string(nil), r.Header.Values(name)...)
+		}
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	return b.String()
+}
+
+// body 依 x-amz-content-sha256 解開分段上傳的格式，並在讀完時檢查內容的 SHA-256 及 Content-MD5
+func (auth *s3Auth) body(r *http.Request) (*s3Body, error) {
+	var body io.Reader = r.Body
+	switch auth.payloadHash {
+	case _s3_UnsignedPayload:
+	case _s3_StreamingSigned:
+		body = &s3ChunkedReader{r: bufio.NewReader(r.Body), auth: auth, prevSignature: auth.signature}
+	case _s3_StreamingUnsignedTrailer:
+		body = &s3ChunkedReader{r: bufio.NewReader(r.Body)}
+	default:
+		want, err := hex.DecodeString(auth.payloadHash)
+		if err != nil || len(want) != sha256.Size {
+			return nil, newS3Error(http.StatusBadRequest, "InvalidArgument", "invalid x-amz-content-sha256")
+		}
+		body = &verifyReader{r: body, h: sha256.New(), want: want,
+			err: newS3Error(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "the provided x-amz-content-sha256 does not match what was computed")}
+	}
+	if contentMD5 := r.Header.Get("Content-Md5"); contentMD5 != "" {
+		want, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(want) != md5.Size {
+			return nil, newS3Error(http.StatusBadRequest, "InvalidDigest", "the Content-MD5 you specified is not valid")
+		}
+		body = &verifyReader{r: body, h: md5.New(), want: want,
+			err: newS3Error(http.StatusBadRequest, "BadDigest", "the Content-MD5 you specified did not match what was received")}
+	}
+	return &s3Body{r: body}, nil
+}
+
+// s3Body 記錄讀取時發生的 s3Error，後端改寫錯誤內容時仍能回應原本的簽章或雜湊錯誤
+type s3Body struct {
+	r   io.Reader
+	err *s3Error
+}
+
+func (b *s3Body) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	var s3Err *s3Error
+	if b.err == nil && errors.As(err, &s3Err) {
+		b.err = s3Err
+	}
+	return n, err
+}
+
+// wrap 讀取 body 失敗時以該錯誤取代 err
+func (b *s3Body) wrap(err error) error {
+	if err != nil && b.err != nil {
+		return b.err
+	}
+	return err
+}
+
+// readXML 讀完整個 body 才解析，確保簽章及雜湊都已驗證
+func (b *s3Body) readXML(v any) error {
+	data, err := io.ReadAll(io.LimitReader(b, _s3_MaxDeleteXML+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > _s3_MaxDeleteXML {
+		return newS3Error(http.StatusBadRequest, "MaxMessageLengthExceeded", "your request was too big")
+	}
+	if err = xml.Unmarshal(data, v); err != nil {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", "the XML you provided was not well-formed")
+	}
+	return nil
+}
+
+// verifyReader 讀到結尾時內容的雜湊與 want 不同則回傳 err
+type verifyReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+	err  error
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.h.Sum(nil), v.want) {
+		return n, v.err
+	}
+	return n, err
+}
+
+// s3ChunkedReader 解開 aws-chunked 格式，auth 不為 nil 時驗證每一段的簽章，驗證通過才回傳該段內容
+type s3ChunkedReader struct {
+	r             *bufio.Reader
+	auth          *s3Auth
+	prevSignature string
+	buf           []byte
+	err           error
+}
+
+func (c *s3ChunkedReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *s3ChunkedReader) next() error {
+	malformed := newS3Error(http.StatusBadRequest, "IncompleteBody", "malformed aws-chunked body")
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return malformed
+	}
+	sizeHex, ext, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > _s3_MaxChunkSize {
+		return malformed
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return malformed
+	}
+	if c.auth != nil {
+		stringToSign := strings.Join([]string{
+			_s3_Algorithm + "-PAYLOAD", c.auth.amzDate, c.auth.scope, c.prevSignature, emptySHA256, sha256Hex(data),
+		}, "\n")
+		signature := hex.EncodeToString(hmacSHA256(c.auth.signingKey, stringToSign))
+		if subtle.ConstantTimeCompare([]byte(signature), []byte(strings.TrimPrefix(ext, "chunk-signature="))) != 1 {
+			return newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "chunk signature does not match")
+		}
+		c.prevSignature = signature
+	}
+	// 最後一段之後只剩 trailer，不需要讀取
+	if size == 0 {
+		return io.EOF
+	}
+	crlf := make([]byte, 2)
+	if _, err = io.ReadFull(c.r, crlf); err != nil || string(crlf) != "\r\n" {
+		return malformed
+	}
+	c.buf = data
+	return nil
+}
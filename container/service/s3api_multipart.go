@@ -0,0 +1,301 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/94peter/storage"
+)
+
+const _s3_MaxPartNumber = 10000
+
+// s3Upload 分段上傳的各段先暫存在本機，完成時再依序串流寫入 channel
+type s3Upload struct {
+	bucket    string
+	key       string
+	accessKey string
+	dir       string
+
+	mu    sync.Mutex
+	etags map[int]string
+	// updated 為最後一次上傳的時間，超過 uploadTTL 的上傳會被移除
+	updated time.Time
+}
+
+type s3InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type s3CompleteMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+var errS3NoSuchUpload = newS3Error(http.StatusNotFound, "NoSuchUpload", "the specified multipart upload does not exist")
+
+// initUploadDir 清空指定的暫存目錄，未指定時建立新的目錄，回傳的 bool 表示 Close 時是否移除整個目錄
+func initUploadDir(dir string) (string, bool, error) {
+	if dir == "" {
+		dir, err := os.MkdirTemp("", "s3api-uploads-")
+		return dir, true, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", false, err
+	}
+	// 上次未正常結束時留下的上傳已無法繼續，直接移除
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false, err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return "", false, err
+		}
+	}
+	return dir, false, nil
+}
+
+// sweepUploads 定期移除超過 uploadTTL 未再上傳的分段上傳
+func (h *s3ApiHandler) sweepUploads() {
+	ticker := time.NewTicker(min(h.uploadTTL, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case now := <-ticker.C:
+			h.mu.Lock()
+			for id, upload := range h.uploads {
+				upload.mu.Lock()
+				expired := now.Sub(upload.updated) > h.uploadTTL
+				upload.mu.Unlock()
+				if expired {
+					delete(h.uploads, id)
+					os.RemoveAll(upload.dir)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// Close 停止清理並移除所有未完成的分段上傳
+func (h *s3ApiHandler) Close() error {
+	var err error
+	h.closeOnce.Do(func() {
+		close(h.done)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for id, upload := range h.uploads {
+			delete(h.uploads, id)
+			os.RemoveAll(upload.dir)
+		}
+		if h.removeUploadDir {
+			err = os.RemoveAll(h.uploadDir)
+		}
+	})
+	return err
+}
+
+func (h *s3ApiHandler) createMultipartUpload(w http.ResponseWriter, auth *s3Auth, bucket, key string) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	open := 0
+	for _, upload := range h.uploads {
+		if upload.accessKey == auth.accessKey {
+			open++
+		}
+	}
+	if open >= h.maxUploadsPerKey {
+		return newS3Error(http.StatusBadRequest, "TooManyUploads", fmt.Sprintf("too many multipart uploads in progress, the limit is %d per access key", h.maxUploadsPerKey))
+	}
+	dir, err := os.MkdirTemp(h.uploadDir, "upload-")
+	if err != nil {
+		return err
+	}
+	h.uploads[uploadID] = &s3Upload{
+		bucket:    bucket,
+		key:       key,
+		accessKey: auth.accessKey,
+		dir:       dir,
+		etags:     map[int]string{},
+		updated:   time.Now(),
+	}
+	writeXML(w, http.StatusOK, s3InitiateMultipartUploadResult{Bucket: bucket, Key: key, UploadId: uploadID})
+	return nil
+}
+
+// getUpload 只有建立上傳的金鑰可以操作該次上傳
+func (h *s3ApiHandler) getUpload(auth *s3Auth, bucket, key, uploadID string) (*s3Upload, error) {
+	h.mu.Lock()
+	upload, ok := h.uploads[uploadID]
+	h.mu.Unlock()
+	if !ok || upload.bucket != bucket || upload.key != key || upload.accessKey != auth.accessKey {
+		return nil, errS3NoSuchUpload
+	}
+	return upload, nil
+}
+
+// removeUpload 回傳 false 表示已被其他請求完成或取消
+func (h *s3ApiHandler) removeUpload(uploadID string, upload *s3Upload) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.uploads[uploadID] != upload {
+		return false
+	}
+	delete(h.uploads, uploadID)
+	os.RemoveAll(upload.dir)
+	return true
+}
+
+func (h *s3ApiHandler) uploadPart(w http.ResponseWriter, r *http.Request, auth *s3Auth, bucket, key string, query url.Values) error {
+	upload, err := h.getUpload(auth, bucket, key, query.Get("uploadId"))
+	if err != nil {
+		return err
+	}
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > _s3_MaxPartNumber {
+		return newS3Error(http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("part number must be an integer between 1 and %d", _s3_MaxPartNumber))
+	}
+	body, err := auth.body(r)
+	if err != nil {
+		return err
+	}
+	// 先寫入暫存檔，同一段重複上傳時才不會讀到寫到一半的內容
+	f, err := os.CreateTemp(upload.dir, "part-")
+	if err != nil {
+		return errS3NoSuchUpload
+	}
+	sum := md5.New()
+	_, err = io.Copy(io.MultiWriter(f, sum), body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), upload.partPath(partNumber))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	tag := `"` + hex.EncodeToString(sum.Sum(nil)) + `"`
+	upload.mu.Lock()
+	upload.etags[partNumber] = tag
+	upload.updated = time.Now()
+	upload.mu.Unlock()
+	w.Header().Set("ETag", tag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (u *s3Upload) partPath(partNumber int) string {
+	return filepath.Join(u.dir, strconv.Itoa(partNumber))
+}
+
+func (h *s3ApiHandler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, auth *s3Auth, sto storage.Storage, bucket, key, uploadID string) error {
+	upload, err := h.getUpload(auth, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	body, err := auth.body(r)
+	if err != nil {
+		return err
+	}
+	var req s3CompleteMultipartUpload
+	if err = body.readXML(&req); err != nil {
+		return err
+	}
+	if len(req.Parts) == 0 {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", "the XML you provided was not well-formed")
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	upload.mu.Lock()
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			err = newS3Error(http.StatusBadRequest, "InvalidPartOrder", "the list of parts was not in ascending order")
+			break
+		}
+		if tag, ok := upload.etags[part.PartNumber]; !ok || strings.Trim(tag, `"`) != strings.Trim(part.ETag, `"`) {
+			err = newS3Error(http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d could not be found", part.PartNumber))
+			break
+		}
+		var f *os.File
+		if f, err = os.Open(upload.partPath(part.PartNumber)); err != nil {
+			break
+		}
+		files = append(files, f)
+	}
+	upload.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		readers[i] = f
+	}
+	if _, err = sto.SaveByReader(key, io.MultiReader(readers...)); err != nil {
+		return err
+	}
+	h.removeUpload(uploadID, upload)
+
+	info, err := statObject(sto, key)
+	if err != nil {
+		return err
+	}
+	writeXML(w, http.StatusOK, s3CompleteMultipartUploadResult{
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag(info),
+	})
+	return nil
+}
+
+func (h *s3ApiHandler) abortMultipartUpload(w http.ResponseWriter, auth *s3Auth, bucket, key, uploadID string) error {
+	upload, err := h.getUpload(auth, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if !h.removeUpload(uploadID, upload) {
+		return errS3NoSuchUpload
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/94peter/storage"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+func newS3ApiServer(t *testing.T) *httptest.Server {
+	return newS3ApiServerWithOptions(t, storage.NewChannelRegistry(map[string]*storage.ChannelConf{
+		"test": {
+			Type:      storage.ChannelMemory,
+			S3ApiKeys: []storage.S3ApiKey{{AccessKey: "access", SecretKey: "secret"}},
+		},
+		"other": {
+			Type:      storage.ChannelMemory,
+			S3ApiKeys: []storage.S3ApiKey{{AccessKey: "other", SecretKey: "secret"}},
+		},
+	}), S3ApiOptions{})
+}
+
+func newS3ApiServerWithOptions(t *testing.T, channels storage.ChannelRegistry, opts S3ApiOptions) *httptest.Server {
+	handler, err := NewS3ApiWithOptions(&storage.Config{Channels: channels}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newS3Client(t *testing.T, server *httptest.Server, bucket, secret string) storage.S3Storage {
+	conf := &storage.S3Conf{
+		Endpoint:   strings.TrimPrefix(server.URL, "http://"),
+		Region:     "us-east-1",
+		AccessKey:  "access",
+		SecretKey:  secret,
+		Bucket:     bucket,
+		DisableSSL: true,
+		PathStyle:  true,
+	}
+	s, err := conf.NewStorage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestS3Api(t *testing.T) {
+	server := newS3ApiServer(t)
+	s := newS3Client(t, server, "test", "secret")
+
+	uploadID, err := s.NewMultipartUpload("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []storage.CompletedPart
+	for i, content := range []string{"hello ", "multipart"} {
+		tag, err := s.UploadPart("big.bin", uploadID, i+1, strings.NewReader(content), int64(len(content)))
+		if err != nil {
+			t.Fatalf("UploadPart(%d): %v", i+1, err)
+		}
+		parts = append(parts, storage.CompletedPart{PartNumber: i + 1, ETag: tag})
+	}
+	if _, err = s.CompleteMultipartUpload("big.bin", uploadID, []storage.CompletedPart{parts[1], parts[0]}); err == nil {
+		t.Fatal("CompleteMultipartUpload out of order: want error")
+	}
+	if _, err = s.CompleteMultipartUpload("big.bin", uploadID, parts); err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	if data, err := s.Get("big.bin"); err != nil || string(data) != "hello multipart" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if err = s.AbortMultipartUpload("big.bin", uploadID); err == nil {
+		t.Fatal("AbortMultipartUpload completed upload: want error")
+	}
+
+	u, err := s.PresignedGetURL("big.bin", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "hello multipart" {
+		t.Fatalf("presigned GET = %d %q", resp.StatusCode, data)
+	}
+	resp, err = http.Get(strings.Replace(u, "X-Amz-Expires=60", "X-Amz-Expires=3600", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("tampered presigned GET = %d, want 403", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/test/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("anonymous GET = %d, want 403", resp.StatusCode)
+	}
+	if _, err = newS3Client(t, server, "test", "wrong").Get("big.bin"); err == nil {
+		t.Fatal("Get with wrong secret: want error")
+	}
+	if _, err = newS3Client(t, server, "other", "secret").Get("big.bin"); err == nil {
+		t.Fatal("Get from channel without the key: want error")
+	}
+}
+
+// TestS3ApiCopyObject 來源以 NewRangeReader 串流讀取，不呼叫 Get 將整個物件讀入記憶體
+func TestS3ApiCopyObject(t *testing.T) {
+	channels := storage.NewChannelRegistry(map[string]*storage.ChannelConf{"test": {
+		Type:      storage.ChannelMemory,
+		S3ApiKeys: []storage.S3ApiKey{{AccessKey: "access", SecretKey: "secret"}},
+	}})
+	server := newS3ApiServerWithOptions(t, channels, S3ApiOptions{})
+	sto, err := channels.NewStorage(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sto.Save("src.txt", []byte("copy me")); err != nil {
+		t.Fatal(err)
+	}
+	sto.(storage.MemStorage).FailOn("Get", 0, errors.New("Get must not be called"))
+
+	client, err := minio.New(strings.TrimPrefix(server.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("access", "secret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: "test", Object: "dst.txt"},
+		minio.CopySrcOptions{Bucket: "test", Object: "src.txt"})
+	if err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+	sto.(storage.MemStorage).ResetFaults()
+	if data, err := sto.Get("dst.txt"); err != nil || string(data) != "copy me" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+}
+
+func TestS3ApiUploadDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stale"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := newS3ApiServerWithOptions(t, storage.NewChannelRegistry(map[string]*storage.ChannelConf{"test": {
+		Type:      storage.ChannelMemory,
+		S3ApiKeys: []storage.S3ApiKey{{AccessKey: "access", SecretKey: "secret"}},
+	}}), S3ApiOptions{UploadDir: dir, UploadTTL: 100 * time.Millisecond, MaxUploadsPerKey: 1})
+	if _, err := os.Stat(filepath.Join(dir, "stale")); !os.IsNotExist(err) {
+		t.Fatalf("stale file not removed on startup: %v", err)
+	}
+	s := newS3Client(t, server, "test", "secret")
+
+	uploadID, err := s.NewMultipartUpload("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.NewMultipartUpload("other.bin"); err == nil {
+		t.Fatal("NewMultipartUpload over the limit: want error")
+	}
+
+	// 超過 TTL 後上傳及暫存目錄都會被移除，並可再建立新的上傳
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upload dir not expired: %v", entries)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err = s.UploadPart("big.bin", uploadID, 1, strings.NewReader("late"), 4); err == nil {
+		t.Fatal("UploadPart to expired upload: want error")
+	}
+	if _, err = s.NewMultipartUpload("other.bin"); err != nil {
+		t.Fatalf("NewMultipartUpload after expiry: %v", err)
+	}
+}
+
+// TestS3ApiPayloadMismatch 內容與 x-amz-content-sha256 不符時回應 400，且不寫入或刪除任何物件
+func TestS3ApiPayloadMismatch(t *testing.T) {
+	server := newS3ApiServer(t)
+	s := newS3Client(t, server, "test", "secret")
+	if _, err := s.Save("keep.txt", []byte("keep")); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, body string) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Amz-Content-Sha256", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+		resp, err := http.DefaultClient.Do(signer.SignV4(*req, "access", "secret", "", "us-east-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(data), "XAmzContentSHA256Mismatch") {
+			t.Fatalf("%s %s = %d %s, want 400 XAmzContentSHA256Mismatch", method, path, resp.StatusCode, data)
+		}
+	}
+	do(http.MethodPut, "/test/put.txt", "tampered")
+	if exist, err := s.FileExist("put.txt"); err != nil || exist {
+		t.Fatalf("FileExist(put.txt) = %v, %v", exist, err)
+	}
+	do(http.MethodPost, "/test?delete", `<Delete><Object><Key>keep.txt</Key></Object></Delete>`)
+	if exist, err := s.FileExist("keep.txt"); err != nil || !exist {
+		t.Fatalf("FileExist(keep.txt) = %v, %v", exist, err)
+	}
+}
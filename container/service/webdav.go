@@ -0,0 +1,66 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/94peter/log"
+	"github.com/94peter/storage"
+	"github.com/94peter/storage/davfs"
+	"golang.org/x/net/webdav"
+)
+
+// NewWebdav 以路徑的第一層區分 channel，例如 /default/a.txt 對應 default channel 的 a.txt，
+// 每個 channel 使用各自的 lock
+func NewWebdav(cfg *storage.Config) http.Handler {
+	return &webdavHandler{
+		channels: cfg.Channels,
+		log:      cfg.Log,
+		locks:    map[string]webdav.LockSystem{},
+	}
+}
+
+type webdavHandler struct {
+	channels storage.ChannelRegistry
+	log      log.Logger
+
+	mu    sync.Mutex
+	locks map[string]webdav.LockSystem
+}
+
+func (h *webdavHandler) lockSystem(channel string) webdav.LockSystem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ls, ok := h.locks[channel]
+	if !ok {
+		ls = webdav.NewMemLS()
+		h.locks[channel] = ls
+	}
+	return ls
+}
+
+func (h *webdavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	channel, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if channel == "" || h.channels.GetChannel(channel) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	sto, err := h.channels.NewStorage(r.Context(), channel)
+	if err != nil {
+		h.log.Errorf("webdav channel [%s]: %v", channel, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handler := &webdav.Handler{
+		Prefix:     "/" + channel,
+		FileSystem: davfs.NewFileSystem(sto),
+		LockSystem: h.lockSystem(channel),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				h.log.Warnf("webdav %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	handler.ServeHTTP(w, r)
+}
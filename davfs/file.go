@@ -0,0 +1,232 @@
+package davfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"time"
+
+	"github.com/94peter/storage"
+	"github.com/pkg/errors"
+)
+
+var (
+	errNotDir   = errors.New("not a directory")
+	errIsDir    = errors.New("is a directory")
+	errReadonly = errors.New("file opened read-only")
+)
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func newFileInfo(o *storage.ObjectInfo) *fileInfo {
+	return &fileInfo{
+		name:    path.Base(o.Key),
+		size:    o.Size,
+		modTime: o.Updated,
+	}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType 實作 webdav.ContentTyper，依副檔名判斷，避免 PROPFIND 時下載檔案內容
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if t := mime.TypeByExtension(path.Ext(fi.name)); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// readFile 第一次讀取時才下載檔案內容。支援 storage.ObjectReader 時 Seek 只記錄位置，
+// Read 時才由該位置開啟 reader，與 rest 的 rangeSeeker 相同
+type readFile struct {
+	fs     *fileSystem
+	key    string
+	info   *fileInfo
+	reader *bytes.Reader
+	offset int64
+	rc     io.ReadCloser
+}
+
+func (f *readFile) load() error {
+	if f.reader != nil {
+		return nil
+	}
+	data, err := f.fs.Get(f.key)
+	if err != nil {
+		return err
+	}
+	f.reader = bytes.NewReader(data)
+	return nil
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.fs.objectReader == nil {
+		if err := f.load(); err != nil {
+			return 0, err
+		}
+		return f.reader.Read(p)
+	}
+	if f.rc == nil {
+		if f.offset >= f.info.size {
+			return 0, io.EOF
+		}
+		rc, err := f.fs.objectReader.NewRangeReader(f.key, f.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.rc = rc
+	}
+	n, err := f.rc.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	if f.fs.objectReader == nil {
+		if err := f.load(); err != nil {
+			return 0, err
+		}
+		return f.reader.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to negative position")
+	}
+	if offset != f.offset {
+		f.Close()
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *readFile) Write(p []byte) (int, error)              { return 0, errReadonly }
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) { return nil, errNotDir }
+func (f *readFile) Stat() (os.FileInfo, error)               { return f.info, nil }
+
+func (f *readFile) Close() error {
+	if f.rc == nil {
+		return nil
+	}
+	err := f.rc.Close()
+	f.rc = nil
+	return err
+}
+
+type dirFile struct {
+	fs      *fileSystem
+	key     string
+	info    *fileInfo
+	entries []os.FileInfo
+	loaded  bool
+}
+
+// Readdir 與 os.File.Readdir 相同，count 大於 0 時分次回傳，沒有更多項目時回傳 io.EOF
+func (f *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.loaded {
+		entries, err := f.fs.readdir(f.key)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.loaded = entries, true
+	}
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *dirFile) Read(p []byte) (int, error)                   { return 0, errIsDir }
+func (f *dirFile) Write(p []byte) (int, error)                  { return 0, errIsDir }
+func (f *dirFile) Seek(offset int64, whence int) (int64, error) { return 0, errIsDir }
+func (f *dirFile) Stat() (os.FileInfo, error)                   { return f.info, nil }
+func (f *dirFile) Close() error                                 { return nil }
+
+// writeFile 寫入本機的暫存檔，Close 時才上傳
+type writeFile struct {
+	fs  *fileSystem
+	key string
+	tmp *os.File
+}
+
+func newWriteFile(fs *fileSystem, key string, data []byte, appendMode bool) (*writeFile, error) {
+	tmp, err := os.CreateTemp("", "davfs-*")
+	if err != nil {
+		return nil, err
+	}
+	f := &writeFile{fs: fs, key: key, tmp: tmp}
+	if len(data) > 0 {
+		if _, err = tmp.Write(data); err == nil && !appendMode {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			f.discard()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *writeFile) discard() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+func (f *writeFile) Read(p []byte) (int, error)  { return f.tmp.Read(p) }
+func (f *writeFile) Write(p []byte) (int, error) { return f.tmp.Write(p) }
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	return f.tmp.Seek(offset, whence)
+}
+
+func (f *writeFile) Readdir(count int) ([]os.FileInfo, error) { return nil, errNotDir }
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	info, err := f.tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(f.key), size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (f *writeFile) Close() error {
+	defer f.discard()
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := f.fs.SaveByReader(f.key, f.tmp)
+	return err
+}
@@ -0,0 +1,374 @@
+// Package davfs 將 storage.Storage 轉為 webdav.FileSystem。
+// 物件儲存沒有目錄，目錄由 key 中的 "/" 推算，空目錄以 _dav_DirMarker 物件保留。
+package davfs
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/94peter/storage"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+// Mkdir 建立的空目錄標記，不會出現在目錄列表中
+const _dav_DirMarker = ".davfs-dir"
+
+// NewFileSystem s 實作 storage.ObjectLister 時由列表取得檔案大小及修改時間，
+// 否則 Stat 需要讀取整個檔案才能得知大小，目錄列表中的檔案大小為 0。
+// s 實作 storage.ObjectReader 時讀取檔案以串流及範圍讀取，不需將整個檔案載入記憶體
+func NewFileSystem(s storage.Storage) webdav.FileSystem {
+	fs := &fileSystem{Storage: s}
+	fs.lister, _ = s.(storage.ObjectLister)
+	fs.prefixDeleter, _ = s.(storage.PrefixDeleter)
+	fs.objectReader, _ = s.(storage.ObjectReader)
+	return fs
+}
+
+type fileSystem struct {
+	storage.Storage
+	lister        storage.ObjectLister
+	prefixDeleter storage.PrefixDeleter
+	objectReader  storage.ObjectReader
+}
+
+// toKey 將 webdav 的路徑轉為 key，根目錄為空字串
+func toKey(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func parent(key string) string {
+	dir := path.Dir(key)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+func (fs *fileSystem) stat(key string) (*fileInfo, error) {
+	if key == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+	if fs.lister != nil {
+		page, err := fs.lister.ListObjects(key, &storage.ListOptions{
+			Recursive:   true,
+			StartOffset: key,
+			EndOffset:   key + "\x00",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Objects {
+			if page.Objects[i].Key == key {
+				return newFileInfo(&page.Objects[i]), nil
+			}
+		}
+	}
+	isDir, err := fs.isDir(key)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		return &fileInfo{name: path.Base(key), dir: true}, nil
+	}
+	if fs.lister != nil {
+		return nil, os.ErrNotExist
+	}
+	// hd 等實作的 FileExist 對目錄也會回傳 true，因此先確認不是目錄
+	exist, err := fs.FileExist(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, os.ErrNotExist
+	}
+	data, err := fs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(key), size: int64(len(data))}, nil
+}
+
+func (fs *fileSystem) isDir(key string) (bool, error) {
+	if key == "" {
+		return true, nil
+	}
+	prefix := key + "/"
+	if fs.lister != nil {
+		page, err := fs.lister.ListObjects(prefix, &storage.ListOptions{Recursive: true, PageSize: 1})
+		if err != nil {
+			return false, err
+		}
+		return len(page.Objects) > 0, nil
+	}
+	list, err := fs.List(prefix)
+	// key 為檔案時 hd 會回傳 ENOTDIR
+	if errors.Is(err, syscall.ENOTDIR) {
+		return false, nil
+	}
+	return len(list) > 0, err
+}
+
+// requireDir key 不是目錄時回傳 os.ErrNotExist，MKCOL 會因此回應 409 Conflict
+func (fs *fileSystem) requireDir(key string) error {
+	isDir, err := fs.isDir(key)
+	if err != nil {
+		return err
+	}
+	if !isDir {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+// keepDir 目錄中的檔案都被移除後，以標記保留目錄
+func (fs *fileSystem) keepDir(key string) error {
+	isDir, err := fs.isDir(key)
+	if err != nil || isDir {
+		return err
+	}
+	_, err = fs.Save(key+"/"+_dav_DirMarker, nil)
+	return err
+}
+
+func (fs *fileSystem) readdir(key string) ([]os.FileInfo, error) {
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+	var infos []os.FileInfo
+	if fs.lister != nil {
+		it := fs.lister.Objects(prefix, &storage.ListOptions{Delimiter: "/"})
+		defer it.Stop()
+		for {
+			o, err := it.Next()
+			if err == storage.IteratorDone {
+				return infos, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if o.Prefix != "" {
+				infos = append(infos, &fileInfo{name: path.Base(o.Prefix), dir: true})
+			} else if path.Base(o.Key) != _dav_DirMarker {
+				infos = append(infos, newFileInfo(o))
+			}
+		}
+	}
+	list, err := fs.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	// List 依實作不同，子目錄可能以 "sub/" 表示或直接列出其下的檔案
+	seen := map[string]bool{}
+	for _, name := range list {
+		rest := strings.TrimPrefix(name, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if !seen[rest[:i]] {
+				seen[rest[:i]] = true
+				infos = append(infos, &fileInfo{name: rest[:i], dir: true})
+			}
+			continue
+		}
+		if rest != "" && rest != _dav_DirMarker {
+			infos = append(infos, &fileInfo{name: rest})
+		}
+	}
+	return infos, nil
+}
+
+// walk 回傳 prefix 之下所有的 key，包含目錄標記
+func (fs *fileSystem) walk(prefix string) ([]string, error) {
+	var keys []string
+	if fs.lister != nil {
+		it := fs.lister.Objects(prefix, &storage.ListOptions{Recursive: true})
+		defer it.Stop()
+		for {
+			o, err := it.Next()
+			if err == storage.IteratorDone {
+				return keys, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, o.Key)
+		}
+	}
+	list, err := fs.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range list {
+		if !strings.HasSuffix(name, "/") {
+			keys = append(keys, name)
+			continue
+		}
+		sub, err := fs.walk(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sub...)
+	}
+	return keys, nil
+}
+
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	key := toKey(name)
+	if key == "" {
+		return os.ErrExist
+	}
+	_, err := fs.stat(key)
+	if err == nil {
+		return os.ErrExist
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err = fs.requireDir(parent(key)); err != nil {
+		return err
+	}
+	_, err = fs.Save(key+"/"+_dav_DirMarker, nil)
+	return err
+}
+
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	key := toKey(name)
+	info, err := fs.stat(key)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	exist := err == nil
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) == 0 {
+		if !exist {
+			return nil, os.ErrNotExist
+		}
+		if info.dir {
+			return &dirFile{fs: fs, key: key, info: info}, nil
+		}
+		return &readFile{fs: fs, key: key, info: info}, nil
+	}
+
+	if exist && info.dir {
+		return nil, errors.Errorf("%s is a directory", name)
+	}
+	if exist && flag&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+	if !exist && flag&os.O_CREATE == 0 {
+		return nil, os.ErrNotExist
+	}
+	if err = fs.requireDir(parent(key)); err != nil {
+		return nil, err
+	}
+	var data []byte
+	if exist && flag&os.O_TRUNC == 0 {
+		if data, err = fs.Get(key); err != nil {
+			return nil, err
+		}
+	}
+	return newWriteFile(fs, key, data, flag&os.O_APPEND != 0)
+}
+
+// RemoveAll 與 os.RemoveAll 相同，name 不存在時不回傳錯誤
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	key := toKey(name)
+	if key == "" {
+		return os.ErrPermission
+	}
+	info, err := fs.stat(key)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.dir {
+		err = fs.deletePrefix(key + "/")
+	} else {
+		err = fs.Delete(key)
+	}
+	if err != nil {
+		return err
+	}
+	return fs.keepDir(parent(key))
+}
+
+func (fs *fileSystem) deletePrefix(prefix string) error {
+	if fs.prefixDeleter != nil {
+		return fs.prefixDeleter.DeletePrefix(prefix)
+	}
+	keys, err := fs.walk(prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = fs.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename 以複製後刪除的方式搬移，目錄會逐一搬移其下所有的檔案
+func (fs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, newKey := toKey(oldName), toKey(newName)
+	if oldKey == "" || newKey == "" {
+		return os.ErrPermission
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if strings.HasPrefix(newKey, oldKey+"/") {
+		return errors.Errorf("cannot move %s into itself", oldName)
+	}
+	info, err := fs.stat(oldKey)
+	if err != nil {
+		return err
+	}
+	if _, err = fs.stat(newKey); err == nil {
+		return os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err = fs.requireDir(parent(newKey)); err != nil {
+		return err
+	}
+	if !info.dir {
+		err = fs.move(oldKey, newKey)
+	} else {
+		var keys []string
+		keys, err = fs.walk(oldKey + "/")
+		for _, key := range keys {
+			if err != nil {
+				break
+			}
+			err = fs.move(key, newKey+strings.TrimPrefix(key, oldKey))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return fs.keepDir(parent(oldKey))
+}
+
+func (fs *fileSystem) move(from, to string) error {
+	data, err := fs.Get(from)
+	if err != nil {
+		return err
+	}
+	if _, err = fs.Save(to, data); err != nil {
+		return err
+	}
+	return fs.Delete(from)
+}
+
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.stat(toKey(name))
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
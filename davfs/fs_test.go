@@ -0,0 +1,236 @@
+package davfs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/94peter/storage"
+	"github.com/94peter/storage/davfs"
+	"golang.org/x/net/webdav"
+)
+
+// basicStorage 只保留 storage.Storage 的方法，測試未實作 ObjectLister 時的行為
+type basicStorage struct {
+	storage.Storage
+}
+
+func TestFileSystem(t *testing.T) {
+	backends := map[string]func(t *testing.T) storage.Storage{
+		"Hd":    func(t *testing.T) storage.Storage { return storage.NewHdStorage(t.TempDir()) },
+		"Mem":   func(t *testing.T) storage.Storage { return storage.NewMemStorage() },
+		"Basic": func(t *testing.T) storage.Storage { return basicStorage{storage.NewMemStorage()} },
+		"BasicHd": func(t *testing.T) storage.Storage {
+			return basicStorage{storage.NewHdStorage(t.TempDir())}
+		},
+	}
+	for name, factory := range backends {
+		t.Run(name, func(t *testing.T) {
+			testFileSystem(t, davfs.NewFileSystem(factory(t)))
+		})
+	}
+}
+
+func writeFile(t *testing.T, fs webdav.FileSystem, name, content string) {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", name, err)
+	}
+	if _, err = io.WriteString(f, content); err != nil {
+		t.Fatalf("Write(%q): %v", name, err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatalf("Close(%q): %v", name, err)
+	}
+}
+
+func readFile(t *testing.T, fs webdav.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("Read(%q): %v", name, err)
+	}
+	return string(data)
+}
+
+func readdir(t *testing.T, fs webdav.FileSystem, name string) []string {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", name, err)
+	}
+	defer f.Close()
+	infos, err := f.Readdir(0)
+	if err != nil {
+		t.Fatalf("Readdir(%q): %v", name, err)
+	}
+	var names []string
+	for _, info := range infos {
+		n := info.Name()
+		if info.IsDir() {
+			n += "/"
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func assertNotExist(t *testing.T, fs webdav.FileSystem, name string) {
+	t.Helper()
+	if _, err := fs.Stat(context.Background(), name); !os.IsNotExist(err) {
+		t.Fatalf("Stat(%q) = %v, want not exist", name, err)
+	}
+}
+
+func testFileSystem(t *testing.T, fs webdav.FileSystem) {
+	ctx := context.Background()
+	if err := fs.Mkdir(ctx, "/docs", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/docs", 0755); !os.IsExist(err) {
+		t.Fatalf("Mkdir existing = %v, want exist", err)
+	}
+	if err := fs.Mkdir(ctx, "/missing/sub", 0755); !os.IsNotExist(err) {
+		t.Fatalf("Mkdir without parent = %v, want not exist", err)
+	}
+	if got := readdir(t, fs, "/docs"); len(got) != 0 {
+		t.Fatalf("Readdir empty dir = %v", got)
+	}
+
+	writeFile(t, fs, "/docs/a.txt", "hello")
+	if _, err := fs.OpenFile(ctx, "/docs/sub/b.txt", os.O_RDWR|os.O_CREATE, 0644); !os.IsNotExist(err) {
+		t.Fatalf("OpenFile without parent = %v, want not exist", err)
+	}
+	if err := fs.Mkdir(ctx, "/docs/sub", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	writeFile(t, fs, "/docs/sub/b.txt", "world")
+	if got := readFile(t, fs, "/docs/a.txt"); got != "hello" {
+		t.Fatalf("read = %q", got)
+	}
+	info, err := fs.Stat(ctx, "/docs/a.txt")
+	if err != nil || info.IsDir() || info.Size() != 5 {
+		t.Fatalf("Stat file = %v, %v", info, err)
+	}
+	if info, err = fs.Stat(ctx, "/docs/sub"); err != nil || !info.IsDir() {
+		t.Fatalf("Stat dir = %v, %v", info, err)
+	}
+	if got := strings.Join(readdir(t, fs, "/docs"), ","); got != "a.txt,sub/" {
+		t.Fatalf("Readdir = %s", got)
+	}
+
+	if err = fs.Rename(ctx, "/docs/a.txt", "/docs/sub/a.txt"); err != nil {
+		t.Fatalf("Rename file: %v", err)
+	}
+	assertNotExist(t, fs, "/docs/a.txt")
+	if err = fs.Rename(ctx, "/docs/sub", "/moved"); err != nil {
+		t.Fatalf("Rename dir: %v", err)
+	}
+	if got := readFile(t, fs, "/moved/a.txt") + readFile(t, fs, "/moved/b.txt"); got != "helloworld" {
+		t.Fatalf("moved content = %q", got)
+	}
+	// 目錄中的檔案都搬走後仍保留目錄
+	if info, err = fs.Stat(ctx, "/docs"); err != nil || !info.IsDir() {
+		t.Fatalf("Stat emptied dir = %v, %v", info, err)
+	}
+
+	if err = fs.RemoveAll(ctx, "/moved"); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	assertNotExist(t, fs, "/moved/a.txt")
+	assertNotExist(t, fs, "/moved")
+	if err = fs.RemoveAll(ctx, "/moved"); err != nil {
+		t.Fatalf("RemoveAll missing: %v", err)
+	}
+	if got := strings.Join(readdir(t, fs, "/"), ","); got != "docs/" {
+		t.Fatalf("Readdir root = %s", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: davfs.NewFileSystem(storage.NewMemStorage()),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+
+	do := func(method, path, body string, header map[string]string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	expect := func(resp *http.Response, code int) {
+		t.Helper()
+		if resp.StatusCode != code {
+			t.Fatalf("%s %s = %d, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, code)
+		}
+	}
+
+	expect(do("MKCOL", "/dir", "", nil), http.StatusCreated)
+	expect(do("PUT", "/dir/a.txt", "hello", nil), http.StatusCreated)
+	expect(do("PUT", "/nodir/a.txt", "hello", nil), http.StatusNotFound)
+	expect(do("MKCOL", "/nodir/sub", "", nil), http.StatusConflict)
+	expect(do("PROPFIND", "/dir", "", map[string]string{"Depth": "1"}), http.StatusMultiStatus)
+	expect(do("MOVE", "/dir/a.txt", "", map[string]string{"Destination": server.URL + "/dir/b.txt"}), http.StatusCreated)
+	resp := do("GET", "/dir/b.txt", "", nil)
+	expect(resp, http.StatusOK)
+	if data, _ := io.ReadAll(resp.Body); string(data) != "hello" {
+		t.Fatalf("GET = %q", data)
+	}
+	expect(do("DELETE", "/dir", "", nil), http.StatusNoContent)
+	expect(do("GET", "/dir/b.txt", "", nil), http.StatusNotFound)
+}
+
+// TestHandlerRange 支援 storage.ObjectReader 時以範圍讀取回應，不下載整個檔案
+func TestHandlerRange(t *testing.T) {
+	mem := storage.NewMemStorage()
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: davfs.NewFileSystem(mem),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+	if _, err := mem.Save("a.txt", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", server.URL+"/a.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=6-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(data) != "world" {
+		t.Fatalf("GET = %d %q", resp.StatusCode, data)
+	}
+	if mem.Calls("Get") != 0 || mem.Calls("NewRangeReader") == 0 {
+		t.Fatalf("calls = %d Get, %d NewRangeReader", mem.Calls("Get"), mem.Calls("NewRangeReader"))
+	}
+}
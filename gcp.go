@@ -16,9 +16,11 @@ import (
 
 	"cloud.google.com/go/iam"
 	googstorage "cloud.google.com/go/storage"
+	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"gopkg.in/yaml.v3"
@@ -38,6 +40,12 @@ type GcpDI interface {
 
 type GcpStorage interface {
 	Storage
+	ConditionalStorage
+	VersionedStorage
+	ObjectLister
+	PrefixDeleter
+	ObjectReader
+	BatchStorage
 	GetAttr(key string) (*googstorage.ObjectAttrs, error)
 	GetDownloadUrl(key string) (myurl *DownloadUrl, err error)
 	Write(key string, writeData func(w io.Writer) error) (path string, err error)
@@ -50,6 +58,12 @@ type GcpConf struct {
 	CredentialsFile string `yaml:"credentailsFile"`
 	CredentailsUrl  string `yaml:"credentailsUrl"`
 	Bucket          string `yaml:"bucket"`
+	// 指定 API 位址，例如本地測試用的 fake-gcs-server
+	Endpoint string `yaml:"endpoint"`
+	// 不使用憑證連線，只適用於 Endpoint 指向的模擬服務，SignedURL 及 GetAccessToken 無法使用
+	NoAuth bool `yaml:"noAuth"`
+	// 暫時性錯誤的重試設定
+	Retry *GcpRetryConf `yaml:"retry"`
 }
 
 func downloadFile(filepath string, url string) error {
@@ -76,6 +90,18 @@ func downloadFile(filepath string, url string) error {
 }
 
 func (gcp *GcpConf) NewStorage(ctx context.Context) (GcpStorage, error) {
+	if gcp.Retry != nil {
+		if err := gcp.Retry.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if gcp.NoAuth {
+		return &storageImpl{
+			ctx:     ctx,
+			bucket:  gcp.Bucket,
+			GcpConf: gcp,
+		}, nil
+	}
 	if gcp.CredentailsUrl != "" {
 		filePath := fmt.Sprintf("/tmp/%s.json", filenameEncode(gcp.CredentailsUrl))
 		if !fileExists(filePath) {
@@ -113,7 +139,39 @@ type storageImpl struct {
 }
 
 func (gcp *storageImpl) getClient() (*googstorage.Client, error) {
-	return googstorage.NewClient(gcp.ctx, option.WithCredentials(gcp.credentials))
+	opts := []option.ClientOption{}
+	if gcp.NoAuth {
+		opts = append(opts, option.WithoutAuthentication())
+	} else {
+		opts = append(opts, option.WithCredentials(gcp.credentials))
+	}
+	if gcp.Endpoint != "" {
+		// 自訂位址時讀取也走 JSON API，不使用固定在 storage.googleapis.com 的 XML API
+		opts = append(opts, option.WithEndpoint(gcp.Endpoint), googstorage.WithJSONReads())
+	}
+	client, err := googstorage.NewClient(gcp.ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if gcp.Retry != nil {
+		client.SetRetry(gcp.Retry.options(gcp.ctx)...)
+	}
+	return client, nil
+}
+
+// conditions 設定 ConditionalWrites 時為沒有前置條件的寫入加上目前 generation 的條件，會多一次 Attrs 請求
+func (gcp *storageImpl) conditions(obj *googstorage.ObjectHandle, conds *googstorage.Conditions) (*googstorage.Conditions, error) {
+	if conds != nil || gcp.Retry == nil || !gcp.Retry.ConditionalWrites {
+		return conds, nil
+	}
+	attrs, err := obj.Attrs(gcp.ctx)
+	if errors.Is(err, googstorage.ErrObjectNotExist) {
+		return &googstorage.Conditions{DoesNotExist: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &googstorage.Conditions{GenerationMatch: attrs.Generation}, nil
 }
 
 func (gcp *storageImpl) Save(filePath string, file []byte) (string, error) {
@@ -131,6 +189,27 @@ func (gcp *storageImpl) SaveByReader(fp string, reader io.Reader) (string, error
 }
 
 func (gcp *storageImpl) Write(key string, writeData func(w io.Writer) error) (path string, err error) {
+	return gcp.write(key, nil, writeData)
+}
+
+func (gcp *storageImpl) SaveIfNotExists(key string, file []byte) (string, error) {
+	return gcp.write(key, &googstorage.Conditions{DoesNotExist: true}, func(w io.Writer) error {
+		_, err := w.Write(file)
+		return err
+	})
+}
+
+func (gcp *storageImpl) SaveIfMatch(key string, file []byte, generation int64) (string, error) {
+	if generation == 0 {
+		return gcp.SaveIfNotExists(key, file)
+	}
+	return gcp.write(key, &googstorage.Conditions{GenerationMatch: generation}, func(w io.Writer) error {
+		_, err := w.Write(file)
+		return err
+	})
+}
+
+func (gcp *storageImpl) write(key string, conds *googstorage.Conditions, writeData func(w io.Writer) error) (path string, err error) {
 	client, err := gcp.getClient()
 	if err != nil {
 		err = fmt.Errorf("storage.NewClient: %v", err)
@@ -138,13 +217,25 @@ func (gcp *storageImpl) Write(key string, writeData func(w io.Writer) error) (pa
 	}
 	defer client.Close()
 
-	wc := client.Bucket(gcp.bucket).Object(key).NewWriter(gcp.ctx)
+	objectHandle := client.Bucket(gcp.bucket).Object(key)
+	if conds, err = gcp.conditions(objectHandle, conds); err != nil {
+		err = fmt.Errorf("createFile: bucket %q, file %q: %v", gcp.bucket, key, err)
+		return
+	}
+	if conds != nil {
+		objectHandle = objectHandle.If(*conds)
+	}
+	wc := objectHandle.NewWriter(gcp.ctx)
 	if err = writeData(wc); err != nil {
-		err = fmt.Errorf("write file error: %s", err.Error())
+		err = fmt.Errorf("write file error: %w", err)
 		return
 	}
 	if err = wc.Close(); err != nil {
-		err = fmt.Errorf("createFile: unable to close bucket %q, file %q: %v", gcp.bucket, key, err)
+		if isPreconditionFailed(err) {
+			err = errors.Wrapf(ErrPreconditionFailed, "createFile: bucket %q, file %q", gcp.bucket, key)
+			return
+		}
+		err = fmt.Errorf("createFile: unable to close bucket %q, file %q: %w", gcp.bucket, key, err)
 		return
 	}
 	path = wc.Attrs().Name
@@ -152,19 +243,65 @@ func (gcp *storageImpl) Write(key string, writeData func(w io.Writer) error) (pa
 }
 
 func (gcp *storageImpl) Delete(key string) error {
+	return gcp.delete(key, nil)
+}
+
+func (gcp *storageImpl) DeleteIfMatch(key string, generation int64) error {
+	if generation == 0 {
+		return errors.Wrapf(ErrPreconditionFailed, "delete: bucket %q, file %q", gcp.bucket, key)
+	}
+	return gcp.delete(key, &googstorage.Conditions{GenerationMatch: generation})
+}
+
+func (gcp *storageImpl) delete(key string, conds *googstorage.Conditions) error {
 	client, err := gcp.getClient()
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %v", err)
 	}
 	defer client.Close()
 
-	if err := client.Bucket(gcp.bucket).Object(key).Delete(gcp.ctx); err != nil {
+	return gcp.deleteObject(client.Bucket(gcp.bucket).Object(key), conds)
+}
+
+func (gcp *storageImpl) deleteObject(objectHandle *googstorage.ObjectHandle, conds *googstorage.Conditions) error {
+	key := objectHandle.ObjectName()
+	conds, err := gcp.conditions(objectHandle, conds)
+	if err != nil {
+		return fmt.Errorf("delete: bucket %q, file %q: %v", gcp.bucket, key, err)
+	}
+	if conds != nil && conds.DoesNotExist {
+		// 物件不存在時直接刪除，回傳原本的不存在錯誤
+		conds = nil
+	}
+	if conds != nil {
+		objectHandle = objectHandle.If(*conds)
+	}
+	if err := objectHandle.Delete(gcp.ctx); err != nil {
+		if isPreconditionFailed(err) {
+			return errors.Wrapf(ErrPreconditionFailed, "delete: bucket %q, file %q", gcp.bucket, key)
+		}
 		return fmt.Errorf("delete: unable to delete object bucket %q, file %q: %v", gcp.bucket, key, err)
 	}
 
 	return nil
 }
 
+func (gcp *storageImpl) Generation(key string) (int64, error) {
+	attrs, err := gcp.GetAttr(key)
+	if errors.Is(err, googstorage.ErrObjectNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Generation, nil
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
 func (gcp *storageImpl) OpenFile(key string) (io.Reader, error) {
 	data, err := gcp.Get(key)
 	if err != nil {
@@ -198,6 +335,58 @@ func (gcp *storageImpl) FileExist(fp string) (bool, error) {
 	return true, nil
 }
 
+// retryer 讓同時進行的呼叫各自計算重試次數
+func (gcp *storageImpl) retryer(objectHandle *googstorage.ObjectHandle) *googstorage.ObjectHandle {
+	if gcp.Retry == nil {
+		return objectHandle
+	}
+	return objectHandle.Retryer(gcp.Retry.options(gcp.ctx)...)
+}
+
+// batch 整批共用同一個 client，每個物件各自計算重試次數
+func (gcp *storageImpl) batch(keys []string, fn func(objectHandle *googstorage.ObjectHandle, result *BatchResult)) ([]BatchResult, error) {
+	client, err := gcp.getClient()
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	bucketHandle := client.Bucket(gcp.bucket)
+	return runBatch(keys, func(key string, result *BatchResult) {
+		fn(gcp.retryer(bucketHandle.Object(key)), result)
+	}), nil
+}
+
+func (gcp *storageImpl) BatchDelete(keys []string) ([]BatchResult, error) {
+	return gcp.batch(keys, func(objectHandle *googstorage.ObjectHandle, result *BatchResult) {
+		result.Err = gcp.deleteObject(objectHandle, nil)
+	})
+}
+
+func (gcp *storageImpl) BatchExist(keys []string) ([]BatchResult, error) {
+	return gcp.batch(keys, func(objectHandle *googstorage.ObjectHandle, result *BatchResult) {
+		_, err := objectHandle.Attrs(gcp.ctx)
+		if errors.Is(err, googstorage.ErrObjectNotExist) {
+			return
+		}
+		result.Exist, result.Err = err == nil, err
+	})
+}
+
+func (gcp *storageImpl) BatchStat(keys []string) ([]BatchResult, error) {
+	return gcp.batch(keys, func(objectHandle *googstorage.ObjectHandle, result *BatchResult) {
+		attrs, err := objectHandle.Attrs(gcp.ctx)
+		if errors.Is(err, googstorage.ErrObjectNotExist) {
+			return
+		}
+		if err != nil {
+			result.Err = err
+			return
+		}
+		result.Exist, result.Object = true, toObjectInfo(attrs)
+	})
+}
+
 const (
 	_Member_AllUsers   = "allUsers"
 	_Role_ObjectReader = iam.RoleName("roles/storage.legacyObjectReader")
@@ -315,6 +504,30 @@ func (gcp *storageImpl) Get(key string) ([]byte, error) {
 	return io.ReadAll(rc)
 }
 
+func (gcp *storageImpl) NewRangeReader(key string, offset, length int64) (io.ReadCloser, error) {
+	client, err := gcp.getClient()
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	rc, err := client.Bucket(gcp.bucket).Object(key).NewRangeReader(gcp.ctx, offset, length)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Object(%q).NewRangeReader: %w", key, err)
+	}
+	return &gcsReader{Reader: rc, client: client}, nil
+}
+
+// gcsReader 讀取結束時一併關閉 client
+type gcsReader struct {
+	*googstorage.Reader
+	client *googstorage.Client
+}
+
+func (r *gcsReader) Close() error {
+	r.Reader.Close()
+	return r.client.Close()
+}
+
 func (gcp *storageImpl) List(dir string) ([]string, error) {
 	client, err := gcp.getClient()
 	if err != nil {
@@ -366,6 +579,7 @@ func (m *gcpConfigMap) GetConfig(key string) *GcpConf {
 	return (*m)[key]
 }
 
+// LoadGcpConfigMap 只支援 gcs，新的設定檔請使用 LoadChannelRegistry
 func LoadGcpConfigMap(file string) (GcpConfigMap, error) {
 	result := make(gcpConfigMap)
 	// read file
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	googstorage "cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
+)
+
+const _gcp_DeleteParallelism = 16
+
+func (gcp *storageImpl) ListObjects(prefix string, opts *ListOptions) (*ListPage, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	client, err := gcp.getClient()
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	query, err := newListQuery(prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	match, err := opts.matcher(true)
+	if err != nil {
+		return nil, err
+	}
+	it := client.Bucket(gcp.bucket).Objects(gcp.ctx, query)
+
+	var attrsList []*googstorage.ObjectAttrs
+	page := &ListPage{}
+	if opts.PageSize > 0 {
+		page.NextToken, err = iterator.NewPager(it, opts.PageSize, opts.PageToken).NextPage(&attrsList)
+		if err != nil {
+			return nil, fmt.Errorf("Bucket(%q).Objects: %w", gcp.bucket, err)
+		}
+	} else {
+		it.PageInfo().Token = opts.PageToken
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Bucket(%q).Objects: %w", gcp.bucket, err)
+			}
+			attrsList = append(attrsList, attrs)
+		}
+	}
+
+	for _, attrs := range attrsList {
+		if attrs.Prefix != "" {
+			page.Prefixes = append(page.Prefixes, attrs.Prefix)
+			continue
+		}
+		// 略過目錄的佔位物件
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+		object := toObjectInfo(attrs)
+		if match(object) {
+			page.Objects = append(page.Objects, *object)
+		}
+	}
+	return page, nil
+}
+
+func (gcp *storageImpl) Objects(prefix string, opts *ListOptions) ObjectIterator {
+	client, err := gcp.getClient()
+	if err != nil {
+		return &gcsIterator{err: fmt.Errorf("storage.NewClient: %v", err)}
+	}
+	query, err := newListQuery(prefix, opts)
+	if err != nil {
+		client.Close()
+		return &gcsIterator{err: err}
+	}
+	match, err := opts.matcher(true)
+	if err != nil {
+		client.Close()
+		return &gcsIterator{err: err}
+	}
+	return &gcsIterator{
+		bucket: gcp.bucket,
+		client: client,
+		it:     client.Bucket(gcp.bucket).Objects(gcp.ctx, query),
+		match:  match,
+	}
+}
+
+// ListMatch 的 glob 由 gcs 的 matchGlob 處理，取回後仍再比對一次，模擬器可能忽略 matchGlob；filters 則在取回後篩選
+func (gcp *storageImpl) ListMatch(pattern string, filters ...ListFilter) ([]ObjectInfo, error) {
+	return listMatch(gcp, pattern, filters...)
+}
+
+func newListQuery(prefix string, opts *ListOptions) (*googstorage.Query, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	query := &googstorage.Query{
+		Prefix:      prefix,
+		Delimiter:   opts.delimiter(),
+		StartOffset: opts.StartOffset,
+		EndOffset:   opts.EndOffset,
+		MatchGlob:   opts.MatchGlob,
+	}
+	err := query.SetAttrSelection([]string{"Name", "Size", "Updated", "Generation"})
+	if err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func toObjectInfo(attrs *googstorage.ObjectAttrs) *ObjectInfo {
+	return &ObjectInfo{
+		Key:        attrs.Name,
+		Size:       attrs.Size,
+		Updated:    attrs.Updated,
+		Generation: attrs.Generation,
+		Prefix:     attrs.Prefix,
+	}
+}
+
+// gcsIterator 持有 client 直到列出結束或呼叫 Stop
+type gcsIterator struct {
+	bucket string
+	client *googstorage.Client
+	it     *googstorage.ObjectIterator
+	match  func(o *ObjectInfo) bool
+	err    error
+}
+
+func (i *gcsIterator) Next() (*ObjectInfo, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	for {
+		attrs, err := i.it.Next()
+		if err == iterator.Done {
+			i.err = IteratorDone
+			i.Stop()
+			return nil, i.err
+		}
+		if err != nil {
+			i.err = fmt.Errorf("Bucket(%q).Objects: %w", i.bucket, err)
+			i.Stop()
+			return nil, i.err
+		}
+		if attrs.Prefix == "" && strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+		object := toObjectInfo(attrs)
+		if !i.match(object) {
+			continue
+		}
+		return object, nil
+	}
+}
+
+func (i *gcsIterator) Stop() {
+	if i.client != nil {
+		i.client.Close()
+		i.client = nil
+	}
+	if i.err == nil {
+		i.err = IteratorDone
+	}
+}
+
+// DeletePrefix 列出 prefix 之下所有物件後平行刪除
+func (gcp *storageImpl) DeletePrefix(prefix string) error {
+	if prefix == "" {
+		return errors.Wrap(ErrInvalidKey, "prefix can not be empty")
+	}
+	client, err := gcp.getClient()
+	if err != nil {
+		return fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	bucketHandle := client.Bucket(gcp.bucket)
+	it := bucketHandle.Objects(gcp.ctx, &googstorage.Query{Prefix: prefix})
+	g, ctx := errgroup.WithContext(gcp.ctx)
+	g.SetLimit(_gcp_DeleteParallelism)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			g.Wait()
+			return fmt.Errorf("Bucket(%q).Objects: %w", gcp.bucket, err)
+		}
+		name := attrs.Name
+		g.Go(func() error {
+			if err := gcp.retryer(bucketHandle.Object(name)).Delete(ctx); err != nil && !errors.Is(err, googstorage.ErrObjectNotExist) {
+				return fmt.Errorf("delete: unable to delete object bucket %q, file %q: %v", gcp.bucket, name, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	googstorage "cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	GcpRetryIdempotent = "idempotent"
+	GcpRetryAlways     = "always"
+	GcpRetryNever      = "never"
+)
+
+// GcpRetryConf 遇到 429、5xx 等暫時性錯誤時的重試設定，等待時間每次乘上 Multiplier 並加上隨機的抖動。
+// 未設定時使用 googstorage 的預設值，只重試冪等的操作且不限次數，直到 ctx 結束
+type GcpRetryConf struct {
+	// idempotent (預設) 只重試讀取及帶有前置條件的寫入，例如 SaveIfMatch、SaveIfNotExists 及 DeleteIfMatch；
+	// always 所有操作都重試，寫入可能重複執行；never 不重試
+	Policy string `yaml:"policy"`
+	// 包含第一次呼叫，0 表示不限次數
+	MaxAttempts int `yaml:"maxAttempts"`
+	// 預設 1s
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	// 預設 30s
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// 預設 2
+	Multiplier float64 `yaml:"multiplier"`
+	// 沒有前置條件的 Save、Write 及 Delete 先以 Attrs 取得目前的 generation 作為前置條件，在 idempotent 下也能安全重試。
+	// 每次寫入會多一次 Attrs 請求，且不再是後寫入者覆蓋：同時寫入同一個 key 時只有一個成功，其他回傳 ErrPreconditionFailed
+	ConditionalWrites bool `yaml:"conditionalWrites"`
+}
+
+func (r *GcpRetryConf) validate() error {
+	switch r.Policy {
+	case "", GcpRetryIdempotent, GcpRetryAlways, GcpRetryNever:
+	default:
+		return errors.Errorf("unknown gcs retry policy %q", r.Policy)
+	}
+	if r.MaxAttempts < 0 {
+		return errors.New("gcs retry maxAttempts must not be negative")
+	}
+	if r.Multiplier != 0 && r.Multiplier < 1 {
+		return errors.New("gcs retry multiplier must be at least 1")
+	}
+	return nil
+}
+
+// options 在同一個 client 或物件上依序進行的呼叫各自計算嘗試次數，例如列表的每一頁。
+// 同時進行的呼叫需以 ObjectHandle.Retryer 各自套用
+func (r *GcpRetryConf) options(ctx context.Context) []googstorage.RetryOption {
+	policy := googstorage.RetryIdempotent
+	switch r.Policy {
+	case GcpRetryAlways:
+		policy = googstorage.RetryAlways
+	case GcpRetryNever:
+		policy = googstorage.RetryNever
+	}
+	opts := []googstorage.RetryOption{
+		googstorage.WithPolicy(policy),
+		googstorage.WithBackoff(gax.Backoff{
+			Initial:    r.InitialBackoff,
+			Max:        r.MaxBackoff,
+			Multiplier: r.Multiplier,
+		}),
+	}
+	if r.MaxAttempts > 0 {
+		opts = append(opts, googstorage.WithErrorFunc(r.shouldRetry(ctx)))
+	}
+	return opts
+}
+
+// shouldRetry 每次嘗試後都會被呼叫，成功時 err 為 nil。
+// 不再重試時呼叫已結束，將次數歸零讓下一個呼叫重新計算；ctx 結束後不再重試也不計入次數
+func (r *GcpRetryConf) shouldRetry(ctx context.Context) func(err error) bool {
+	var attempts int32
+	return func(err error) bool {
+		if ctx.Err() == nil && googstorage.ShouldRetry(err) && int(atomic.AddInt32(&attempts, 1)) < r.MaxAttempts {
+			return true
+		}
+		atomic.StoreInt32(&attempts, 0)
+		return false
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// TestGcpRetryAttemptsPerCall 每個呼叫結束後重新計算次數，不會用完整個 client 共用的次數
+func TestGcpRetryAttemptsPerCall(t *testing.T) {
+	conf := &GcpRetryConf{MaxAttempts: 3}
+	shouldRetry := conf.shouldRetry(context.Background())
+	transient := &googleapi.Error{Code: 503}
+	for call := 0; call < 3; call++ {
+		if !shouldRetry(transient) || !shouldRetry(transient) {
+			t.Fatalf("call %d: should retry before MaxAttempts", call)
+		}
+		if shouldRetry(transient) {
+			t.Fatalf("call %d: should stop at MaxAttempts", call)
+		}
+	}
+	// 成功的呼叫同樣讓次數歸零
+	shouldRetry(transient)
+	shouldRetry(nil)
+	if !shouldRetry(transient) || !shouldRetry(transient) {
+		t.Fatal("attempts not reset after success")
+	}
+}
+
+// TestGcpRetryCanceled ctx 結束後不再重試，次數歸零不影響之後的呼叫
+func TestGcpRetryCanceled(t *testing.T) {
+	conf := &GcpRetryConf{MaxAttempts: 3}
+	ctx, cancel := context.WithCancel(context.Background())
+	shouldRetry := conf.shouldRetry(ctx)
+	transient := &googleapi.Error{Code: 503}
+	if !shouldRetry(transient) {
+		t.Fatal("should retry before cancel")
+	}
+	cancel()
+	if shouldRetry(transient) {
+		t.Fatal("should not retry after cancel")
+	}
+	if shouldRetry(context.Canceled) {
+		t.Fatal("should not retry context error")
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	googstorage "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+func (gcp *storageImpl) ListVersions(key string) ([]*ObjectVersion, error) {
+	client, err := gcp.getClient()
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	var result []*ObjectVersion
+	it := client.Bucket(gcp.bucket).Objects(gcp.ctx, &googstorage.Query{
+		Prefix:   key,
+		Versions: true,
+	})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Bucket(%q).Objects: %w", gcp.bucket, err)
+		}
+		if attrs.Name != key {
+			continue
+		}
+		result = append(result, &ObjectVersion{
+			Key:        attrs.Name,
+			Generation: attrs.Generation,
+			Size:       attrs.Size,
+			Updated:    attrs.Updated,
+			// 非目前版本會有刪除時間
+			IsLatest: attrs.Deleted.IsZero(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Generation > result[j].Generation
+	})
+	return result, nil
+}
+
+func (gcp *storageImpl) GetVersion(key string, generation int64) ([]byte, error) {
+	client, err := gcp.getClient()
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	rc, err := client.Bucket(gcp.bucket).Object(key).Generation(generation).NewReader(gcp.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Object(%q).Generation(%d).NewReader: %w", key, generation, err)
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (gcp *storageImpl) RestoreVersion(key string, generation int64) (string, error) {
+	client, err := gcp.getClient()
+	if err != nil {
+		return "", fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	objectHandle := client.Bucket(gcp.bucket).Object(key)
+	attrs, err := objectHandle.CopierFrom(objectHandle.Generation(generation)).Run(gcp.ctx)
+	if err != nil {
+		return "", fmt.Errorf("restore: unable to copy bucket %q, file %q#%d: %v", gcp.bucket, key, generation, err)
+	}
+	return attrs.Name, nil
+}
+
+func (gcp *storageImpl) DeleteVersion(key string, generation int64) error {
+	client, err := gcp.getClient()
+	if err != nil {
+		return fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Bucket(gcp.bucket).Object(key).Generation(generation).Delete(gcp.ctx); err != nil {
+		return fmt.Errorf("delete: unable to delete object bucket %q, file %q#%d: %v", gcp.bucket, key, generation, err)
+	}
+	return nil
+}
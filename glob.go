@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ListFilter 篩選列出的物件，零值的欄位不做限制
+type ListFilter struct {
+	MinSize       int64
+	MaxSize       int64
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+}
+
+func MinSize(n int64) ListFilter {
+	return ListFilter{MinSize: n}
+}
+
+func MaxSize(n int64) ListFilter {
+	return ListFilter{MaxSize: n}
+}
+
+func UpdatedAfter(t time.Time) ListFilter {
+	return ListFilter{UpdatedAfter: t}
+}
+
+func UpdatedBefore(t time.Time) ListFilter {
+	return ListFilter{UpdatedBefore: t}
+}
+
+// mergeFilters 將多個條件合併為同時滿足所有條件的 ListFilter
+func mergeFilters(filters ...ListFilter) ListFilter {
+	var result ListFilter
+	for _, f := range filters {
+		if f.MinSize > result.MinSize {
+			result.MinSize = f.MinSize
+		}
+		if f.MaxSize != 0 && (result.MaxSize == 0 || f.MaxSize < result.MaxSize) {
+			result.MaxSize = f.MaxSize
+		}
+		if f.UpdatedAfter.After(result.UpdatedAfter) {
+			result.UpdatedAfter = f.UpdatedAfter
+		}
+		if !f.UpdatedBefore.IsZero() && (result.UpdatedBefore.IsZero() || f.UpdatedBefore.Before(result.UpdatedBefore)) {
+			result.UpdatedBefore = f.UpdatedBefore
+		}
+	}
+	return result
+}
+
+func (f *ListFilter) match(o *ObjectInfo) bool {
+	if f.MinSize != 0 && o.Size < f.MinSize {
+		return false
+	}
+	if f.MaxSize != 0 && o.Size > f.MaxSize {
+		return false
+	}
+	if !f.UpdatedAfter.IsZero() && !o.Updated.After(f.UpdatedAfter) {
+		return false
+	}
+	if !f.UpdatedBefore.IsZero() && !o.Updated.Before(f.UpdatedBefore) {
+		return false
+	}
+	return true
+}
+
+type ObjectMatcher interface {
+	// ListMatch 列出符合 glob pattern 及所有 filters 的物件，"**" 可跨越目錄
+	ListMatch(pattern string, filters ...ListFilter) ([]ObjectInfo, error)
+}
+
+// listMatch 以 Objects 實作 ListMatch
+func listMatch(lister ObjectLister, pattern string, filters ...ListFilter) ([]ObjectInfo, error) {
+	if _, err := compileGlob(pattern); err != nil {
+		return nil, err
+	}
+	it := lister.Objects(globPrefix(pattern), &ListOptions{
+		Recursive: true,
+		MatchGlob: pattern,
+		Filter:    mergeFilters(filters...),
+	})
+	defer it.Stop()
+	var result []ObjectInfo
+	for {
+		object, err := it.Next()
+		if err == IteratorDone {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *object)
+	}
+}
+
+// globPrefix 回傳 pattern 中第一個萬用字元之前的部分
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[{\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// compileGlob 將與 gcs matchGlob 相同語法的 pattern 轉為 regexp
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("^")
+	inBrace := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					buf.WriteString("(?:.*/)?")
+				} else {
+					buf.WriteString(".*")
+				}
+			} else {
+				buf.WriteString("[^/]*")
+			}
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errors.Errorf("invalid glob pattern: %s", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '{':
+			if inBrace {
+				return nil, errors.Errorf("invalid glob pattern: %s", pattern)
+			}
+			inBrace = true
+			buf.WriteString("(?:")
+		case '}':
+			if !inBrace {
+				return nil, errors.Errorf("invalid glob pattern: %s", pattern)
+			}
+			inBrace = false
+			buf.WriteString(")")
+		case ',':
+			if inBrace {
+				buf.WriteString("|")
+			} else {
+				buf.WriteString(",")
+			}
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				i += writeLiteral(&buf, pattern[i:]) - 1
+			}
+		default:
+			i += writeLiteral(&buf, pattern[i:]) - 1
+		}
+	}
+	if inBrace {
+		return nil, errors.Errorf("invalid glob pattern: %s", pattern)
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// writeLiteral 寫入 s 的第一個字元，多位元組的 utf-8 字元需整個寫入，回傳使用的位元組數
+func writeLiteral(buf *strings.Builder, s string) int {
+	_, size := utf8.DecodeRuneInString(s)
+	buf.WriteString(regexp.QuoteMeta(s[:size]))
+	return size
+}
@@ -9,38 +9,59 @@ import (
 
 	googstorage "cloud.google.com/go/storage"
 	"github.com/94peter/storage/grpc/pb"
+	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// 與服務單一批次請求的 key 數量上限相同
+const _grpc_BatchSize = 1000
+
 type GrpcGcpStorage interface {
 	GcpStorage
 	Close()
 }
 
 func NewGrpcGcpStorage(ctx context.Context, address string, channel string) (GrpcGcpStorage, error) {
-	md := metadata.New(map[string]string{"X-Channel": channel})
-	ctx = metadata.NewOutgoingContext(ctx, md)
-	conn, err := getClient(ctx, address)
+	return NewGrpcGcpStorageWithOptions(ctx, address, channel, GrpcClientOptions{})
+}
+
+// NewGrpcGcpStorageWithOptions ctx 會用於之後所有的 rpc，ctx 結束後無法再使用
+func NewGrpcGcpStorageWithOptions(ctx context.Context, address string, channel string, opts GrpcClientOptions) (GrpcGcpStorage, error) {
+	conn, err := getClient(ctx, address, &opts)
 	if err != nil {
 		return nil, err
 	}
+	return NewGrpcGcpStorageWithConn(ctx, conn, channel), nil
+}
+
+// NewGrpcGcpStorageWithConn 使用已建立的連線，Close 時會一併關閉 conn
+func NewGrpcGcpStorageWithConn(ctx context.Context, conn *grpc.ClientConn, channel string) GrpcGcpStorage {
+	md := metadata.New(map[string]string{"X-Channel": channel})
 	return &grpcStorage{
-		ctx:  ctx,
+		ctx:  metadata.NewOutgoingContext(ctx, md),
 		conn: conn,
-	}, nil
+	}
 }
 
-func getClient(ctx context.Context, address string) (*grpc.ClientConn, error) {
-	conn, err := grpc.DialContext(ctx, address,
-		grpc.WithTransportCredentials(
-			insecure.NewCredentials(),
-		),
-		grpc.WithBlock(),
-	)
+func getClient(ctx context.Context, address string, opts *GrpcClientOptions) (*grpc.ClientConn, error) {
+	dialOpts, err := opts.dialOptions(address)
+	if err != nil {
+		return nil, fmt.Errorf("address [%s] error: %s", address, err.Error())
+	}
+	timeout := opts.DialTimeout
+	if timeout <= 0 {
+		timeout = _grpc_DialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, address, dialOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("address [%s] error: %s", address, err.Error())
 	}
@@ -74,7 +95,7 @@ func (gcp *grpcStorage) Write(key string, writeData func(w io.Writer) error) (pa
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	buf := new(bytes.Buffer)
 	if err = writeData(buf); err != nil {
-		err = fmt.Errorf("write file error: %s", err.Error())
+		err = fmt.Errorf("write file error: %w", err)
 		return
 	}
 	url, err := clt.SaveFile(gcp.ctx, &pb.SaveFileRequest{
@@ -82,27 +103,138 @@ func (gcp *grpcStorage) Write(key string, writeData func(w io.Writer) error) (pa
 		File: buf.Bytes(),
 	})
 	if err != nil {
+		err = fromGrpcError(err)
 		return
 	}
 	path = url.Url
 	return
 }
 
+func (gcp *grpcStorage) SaveIfNotExists(key string, file []byte) (string, error) {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	url, err := clt.SaveFile(gcp.ctx, &pb.SaveFileRequest{
+		Key:         key,
+		File:        file,
+		IfNotExists: true,
+	})
+	if err != nil {
+		return "", fromGrpcError(err)
+	}
+	return url.Url, nil
+}
+
+func (gcp *grpcStorage) SaveIfMatch(key string, file []byte, generation int64) (string, error) {
+	if generation == 0 {
+		return gcp.SaveIfNotExists(key, file)
+	}
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	url, err := clt.SaveFile(gcp.ctx, &pb.SaveFileRequest{
+		Key:               key,
+		File:              file,
+		IfGenerationMatch: generation,
+	})
+	if err != nil {
+		return "", fromGrpcError(err)
+	}
+	return url.Url, nil
+}
+
 func (gcp *grpcStorage) Delete(key string) error {
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	_, err := clt.Delete(gcp.ctx, &pb.ObjectKey{Key: key})
-	return err
+	return fromGrpcError(err)
+}
+
+func (gcp *grpcStorage) DeleteIfMatch(key string, generation int64) error {
+	if generation == 0 {
+		return errors.Wrapf(ErrPreconditionFailed, "delete: file %q", key)
+	}
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	_, err := clt.Delete(gcp.ctx, &pb.ObjectKey{Key: key, IfGenerationMatch: generation})
+	return fromGrpcError(err)
+}
+
+func (gcp *grpcStorage) DeletePrefix(prefix string) error {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	_, err := clt.DeletePrefix(gcp.ctx, &pb.Dir{Path: prefix})
+	return fromGrpcError(err)
+}
+
+// BatchDelete 超過 _grpc_BatchSize 個 key 時分成多個 rpc
+func (gcp *grpcStorage) BatchDelete(keys []string) ([]BatchResult, error) {
+	return gcp.batch(keys, pb.GcpServiceClient.BatchDelete)
+}
+
+func (gcp *grpcStorage) BatchExist(keys []string) ([]BatchResult, error) {
+	return gcp.batch(keys, pb.GcpServiceClient.BatchExist)
+}
+
+func (gcp *grpcStorage) BatchStat(keys []string) ([]BatchResult, error) {
+	return gcp.batch(keys, pb.GcpServiceClient.BatchStat)
+}
+
+type grpcBatchCall func(clt pb.GcpServiceClient, ctx context.Context, in *pb.BatchRequest, opts ...grpc.CallOption) (*pb.BatchResponse, error)
+
+// batch 之後的 rpc 失敗時回傳已完成的結果，尚未處理的 key 以該 rpc 的錯誤作為 Err，
+// 只有第一個 rpc 就失敗時才回傳 error
+func (gcp *grpcStorage) batch(keys []string, call grpcBatchCall) ([]BatchResult, error) {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	results := make([]BatchResult, 0, len(keys))
+	for start := 0; start < len(keys); start += _grpc_BatchSize {
+		rsp, err := call(clt, gcp.ctx, &pb.BatchRequest{Keys: keys[start:min(start+_grpc_BatchSize, len(keys))]})
+		if err != nil {
+			err = fromGrpcError(err)
+			if start == 0 {
+				return nil, err
+			}
+			for _, key := range keys[start:] {
+				results = append(results, BatchResult{Key: key, Err: err})
+			}
+			return results, nil
+		}
+		for _, r := range rsp.Results {
+			result := BatchResult{Key: r.Key, Exist: r.Exist}
+			if r.Object != nil {
+				result.Object = fromPbObjectInfo(r.Object)
+			}
+			if code := codes.Code(r.ErrorCode); code != codes.OK {
+				if result.Err = fromGrpcReason(code, r.ErrorMessage, r.ErrorReason); result.Err == nil {
+					result.Err = status.Error(code, r.ErrorMessage)
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (gcp *grpcStorage) Generation(key string) (int64, error) {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	rsp, err := clt.Exist(gcp.ctx, &pb.ObjectKey{Key: key})
+	if err != nil {
+		return 0, fromGrpcError(err)
+	}
+	return rsp.Generation, nil
 }
 
 func (gcp *grpcStorage) OpenFile(key string) (io.Reader, error) {
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	file, err := clt.GetFile(gcp.ctx, &pb.ObjectKey{Key: key})
 	if err != nil {
-		return nil, err
+		return nil, fromGrpcError(err)
 	}
 	return bytes.NewReader(file.File), nil
 }
 
+// NewRangeReader 服務沒有串流下載的 rpc，仍會下載整個檔案
+func (gcp *grpcStorage) NewRangeReader(key string, offset, length int64) (io.ReadCloser, error) {
+	data, err := gcp.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(byteRange(data, offset, length))), nil
+}
+
 func (gcp *grpcStorage) GetAttr(key string) (*googstorage.ObjectAttrs, error) {
 	panic("not implemented")
 }
@@ -111,7 +243,7 @@ func (gcp *grpcStorage) FileExist(fp string) (bool, error) {
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	rsp, err := clt.Exist(gcp.ctx, &pb.ObjectKey{Key: fp})
 	if err != nil {
-		return false, err
+		return false, fromGrpcError(err)
 	}
 	return rsp.Exist, nil
 }
@@ -120,7 +252,7 @@ func (gcp *grpcStorage) GetDownloadUrl(key string) (myurl *DownloadUrl, err erro
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	url, err := clt.GetDownloadUrl(gcp.ctx, &pb.ObjectKey{Key: key})
 	if err != nil {
-		return nil, err
+		return nil, fromGrpcError(err)
 	}
 	myurl = &DownloadUrl{
 		Url:      url.Url,
@@ -141,7 +273,7 @@ func (gcp *grpcStorage) SignedURL(key string, contentType string, expirationDura
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	url, err := clt.GetSignedUrl(gcp.ctx, &pb.GetSignedUrlRequest{Key: key, ContentType: contentType, ExpireSecs: uint32(expirationDuration / time.Second)})
 	if err != nil {
-		return "", err
+		return "", fromGrpcError(err)
 	}
 	return url.Url, nil
 }
@@ -150,7 +282,7 @@ func (gcp *grpcStorage) GetAccessToken() (*oauth2.Token, error) {
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	token, err := clt.GetAccessToken(gcp.ctx, &emptypb.Empty{})
 	if err != nil {
-		return nil, err
+		return nil, fromGrpcError(err)
 	}
 	return &oauth2.Token{
 		AccessToken:  token.AccessToken,
@@ -164,7 +296,7 @@ func (gcp *grpcStorage) Get(key string) ([]byte, error) {
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	file, err := clt.GetFile(gcp.ctx, &pb.ObjectKey{Key: key})
 	if err != nil {
-		return nil, err
+		return nil, fromGrpcError(err)
 	}
 	return file.File, nil
 }
@@ -173,7 +305,212 @@ func (gcp *grpcStorage) List(dir string) ([]string, error) {
 	clt := pb.NewGcpServiceClient(gcp.conn)
 	rsp, err := clt.List(gcp.ctx, &pb.Dir{Path: dir})
 	if err != nil {
-		return nil, err
+		return nil, fromGrpcError(err)
 	}
 	return rsp.Files, nil
 }
+
+func (gcp *grpcStorage) ListObjects(prefix string, opts *ListOptions) (*ListPage, error) {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	rsp, err := clt.ListObjects(gcp.ctx, toPbDir(prefix, opts))
+	if err != nil {
+		return nil, fromGrpcError(err)
+	}
+	page := &ListPage{
+		Prefixes:  rsp.Prefixes,
+		NextToken: rsp.NextToken,
+	}
+	for _, o := range rsp.Objects {
+		page.Objects = append(page.Objects, *fromPbObjectInfo(o))
+	}
+	return page, nil
+}
+
+func (gcp *grpcStorage) Objects(prefix string, opts *ListOptions) ObjectIterator {
+	ctx, cancel := context.WithCancel(gcp.ctx)
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	stream, err := clt.ListStream(ctx, toPbDir(prefix, opts))
+	if err != nil {
+		cancel()
+		return &grpcIterator{err: fromGrpcError(err)}
+	}
+	return &grpcIterator{stream: stream, cancel: cancel}
+}
+
+func (gcp *grpcStorage) ListMatch(pattern string, filters ...ListFilter) ([]ObjectInfo, error) {
+	return listMatch(gcp, pattern, filters...)
+}
+
+type grpcIterator struct {
+	stream pb.GcpService_ListStreamClient
+	cancel context.CancelFunc
+	err    error
+}
+
+func (i *grpcIterator) Next() (*ObjectInfo, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	item, err := i.stream.Recv()
+	if err == io.EOF {
+		i.Stop()
+		return nil, i.err
+	}
+	if err != nil {
+		i.Stop()
+		i.err = fromGrpcError(err)
+		return nil, i.err
+	}
+	if item.Prefix != "" {
+		return &ObjectInfo{Prefix: item.Prefix}, nil
+	}
+	if item.Object == nil {
+		i.Stop()
+		i.err = errors.New("list item has neither prefix nor object")
+		return nil, i.err
+	}
+	return fromPbObjectInfo(item.Object), nil
+}
+
+func (i *grpcIterator) Stop() {
+	if i.cancel != nil {
+		i.cancel()
+		i.cancel = nil
+	}
+	if i.err == nil {
+		i.err = IteratorDone
+	}
+}
+
+func toPbDir(prefix string, opts *ListOptions) *pb.Dir {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	dir := &pb.Dir{
+		Path:        prefix,
+		Delimiter:   opts.Delimiter,
+		Recursive:   opts.Recursive,
+		PageSize:    int32(opts.PageSize),
+		PageToken:   opts.PageToken,
+		StartOffset: opts.StartOffset,
+		EndOffset:   opts.EndOffset,
+		MatchGlob:   opts.MatchGlob,
+		MinSize:     opts.Filter.MinSize,
+		MaxSize:     opts.Filter.MaxSize,
+	}
+	// 同時送出 unix 秒數，舊版服務仍可套用以秒為單位的條件
+	if !opts.Filter.UpdatedAfter.IsZero() {
+		dir.UpdatedAfter = opts.Filter.UpdatedAfter.Unix()
+		dir.UpdatedAfterTime = timestamppb.New(opts.Filter.UpdatedAfter)
+	}
+	if !opts.Filter.UpdatedBefore.IsZero() {
+		dir.UpdatedBefore = opts.Filter.UpdatedBefore.Unix()
+		dir.UpdatedBeforeTime = timestamppb.New(opts.Filter.UpdatedBefore)
+	}
+	return dir
+}
+
+func fromPbObjectInfo(o *pb.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:        o.Key,
+		Size:       o.Size,
+		Updated:    fromPbTime(o.UpdatedTime, o.Updated),
+		Generation: o.Generation,
+	}
+}
+
+// fromPbTime 舊版服務沒有 Timestamp，只有 unix 秒數
+func fromPbTime(t *timestamppb.Timestamp, unix int64) time.Time {
+	if t == nil {
+		return time.Unix(unix, 0)
+	}
+	return t.AsTime().Local()
+}
+
+func (gcp *grpcStorage) ListVersions(key string) ([]*ObjectVersion, error) {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	rsp, err := clt.ListVersions(gcp.ctx, &pb.ObjectKey{Key: key})
+	if err != nil {
+		return nil, fromGrpcError(err)
+	}
+	result := make([]*ObjectVersion, len(rsp.Versions))
+	for i, v := range rsp.Versions {
+		result[i] = &ObjectVersion{
+			Key:        v.Key,
+			Generation: v.Generation,
+			Size:       v.Size,
+			Updated:    fromPbTime(v.UpdatedTime, v.Updated),
+			IsLatest:   v.IsLatest,
+		}
+	}
+	return result, nil
+}
+
+func (gcp *grpcStorage) GetVersion(key string, generation int64) ([]byte, error) {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	file, err := clt.GetVersion(gcp.ctx, &pb.ObjectKey{Key: key, Generation: generation})
+	if err != nil {
+		return nil, fromGrpcError(err)
+	}
+	return file.File, nil
+}
+
+func (gcp *grpcStorage) RestoreVersion(key string, generation int64) (string, error) {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	url, err := clt.RestoreVersion(gcp.ctx, &pb.ObjectKey{Key: key, Generation: generation})
+	if err != nil {
+		return "", fromGrpcError(err)
+	}
+	return url.Url, nil
+}
+
+func (gcp *grpcStorage) DeleteVersion(key string, generation int64) error {
+	clt := pb.NewGcpServiceClient(gcp.conn)
+	_, err := clt.DeleteVersion(gcp.ctx, &pb.ObjectKey{Key: key, Generation: generation})
+	return fromGrpcError(err)
+}
+
+// gRPC 錯誤的 ErrorInfo，服務以 reason 標示 storage 的錯誤。
+// 相同 code 的錯誤也可能來自 grpc 本身或服務的其他檢查，例如 channel 不存在，client 只轉換帶有 reason 的錯誤
+const (
+	GrpcErrorDomain         = "storage.94peter"
+	GrpcReasonInvalidKey    = "INVALID_KEY"
+	GrpcReasonQuotaExceeded = "QUOTA_EXCEEDED"
+)
+
+// fromGrpcError 將服務端 toStatusError 轉換的 status 還原為 ErrPreconditionFailed、ErrInvalidKey 及 ErrQuotaExceeded
+func fromGrpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if mapped := fromGrpcReason(s.Code(), s.Message(), grpcErrorReason(s)); mapped != nil {
+		return mapped
+	}
+	return err
+}
+
+// fromGrpcReason 無法轉換時回傳 nil
+func fromGrpcReason(code codes.Code, message, reason string) error {
+	switch {
+	case code == codes.FailedPrecondition:
+		return errors.Wrap(ErrPreconditionFailed, message)
+	case code == codes.InvalidArgument && reason == GrpcReasonInvalidKey:
+		return errors.Wrap(ErrInvalidKey, message)
+	case code == codes.ResourceExhausted && reason == GrpcReasonQuotaExceeded:
+		return errors.Wrap(ErrQuotaExceeded, message)
+	}
+	return nil
+}
+
+func grpcErrorReason(s *status.Status) string {
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == GrpcErrorDomain {
+			return info.Reason
+		}
+	}
+	return ""
+}
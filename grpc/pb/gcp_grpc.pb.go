@@ -39,6 +39,26 @@ type GcpServiceClient interface {
 	Exist(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*ExistResponse, error)
 	// 列出
 	List(ctx context.Context, in *Dir, opts ...grpc.CallOption) (*ListResponse, error)
+	// 分頁列出物件及子目錄
+	ListObjects(ctx context.Context, in *Dir, opts ...grpc.CallOption) (*ListResponse, error)
+	// 以串流逐筆列出物件及子目錄
+	ListStream(ctx context.Context, in *Dir, opts ...grpc.CallOption) (GcpService_ListStreamClient, error)
+	// 刪除目錄下所有的物件
+	DeletePrefix(ctx context.Context, in *Dir, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// 列出物件的所有版本
+	ListVersions(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*VersionList, error)
+	// 取得指定版本的檔案
+	GetVersion(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*File, error)
+	// 將指定版本還原為目前版本
+	RestoreVersion(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*Url, error)
+	// 刪除指定版本
+	DeleteVersion(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// 批次刪除
+	BatchDelete(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// 批次檢查檔案是否存在
+	BatchExist(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// 批次取得物件資訊
+	BatchStat(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
 }
 
 type gcpServiceClient struct {
@@ -121,6 +141,119 @@ func (c *gcpServiceClient) List(ctx context.Context, in *Dir, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *gcpServiceClient) ListObjects(ctx context.Context, in *Dir, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/ListObjects", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) ListStream(ctx context.Context, in *Dir, opts ...grpc.CallOption) (GcpService_ListStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GcpService_ServiceDesc.Streams[0], "/storage.GcpService/ListStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gcpServiceListStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GcpService_ListStreamClient interface {
+	Recv() (*ListItem, error)
+	grpc.ClientStream
+}
+
+type gcpServiceListStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *gcpServiceListStreamClient) Recv() (*ListItem, error) {
+	m := new(ListItem)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *gcpServiceClient) DeletePrefix(ctx context.Context, in *Dir, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/DeletePrefix", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) ListVersions(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*VersionList, error) {
+	out := new(VersionList)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/ListVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) GetVersion(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*File, error) {
+	out := new(File)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/GetVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) RestoreVersion(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*Url, error) {
+	out := new(Url)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/RestoreVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) DeleteVersion(ctx context.Context, in *ObjectKey, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/DeleteVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) BatchDelete(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/BatchDelete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) BatchExist(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/BatchExist", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gcpServiceClient) BatchStat(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/storage.GcpService/BatchStat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GcpServiceServer is the server API for GcpService service.
 // All implementations must embed UnimplementedGcpServiceServer
 // for forward compatibility
@@ -141,6 +274,26 @@ type GcpServiceServer interface {
 	Exist(context.Context, *ObjectKey) (*ExistResponse, error)
 	// 列出
 	List(context.Context, *Dir) (*ListResponse, error)
+	// 分頁列出物件及子目錄
+	ListObjects(context.Context, *Dir) (*ListResponse, error)
+	// 以串流逐筆列出物件及子目錄
+	ListStream(*Dir, GcpService_ListStreamServer) error
+	// 刪除目錄下所有的物件
+	DeletePrefix(context.Context, *Dir) (*emptypb.Empty, error)
+	// 列出物件的所有版本
+	ListVersions(context.Context, *ObjectKey) (*VersionList, error)
+	// 取得指定版本的檔案
+	GetVersion(context.Context, *ObjectKey) (*File, error)
+	// 將指定版本還原為目前版本
+	RestoreVersion(context.Context, *ObjectKey) (*Url, error)
+	// 刪除指定版本
+	DeleteVersion(context.Context, *ObjectKey) (*emptypb.Empty, error)
+	// 批次刪除
+	BatchDelete(context.Context, *BatchRequest) (*BatchResponse, error)
+	// 批次檢查檔案是否存在
+	BatchExist(context.Context, *BatchRequest) (*BatchResponse, error)
+	// 批次取得物件資訊
+	BatchStat(context.Context, *BatchRequest) (*BatchResponse, error)
 	mustEmbedUnimplementedGcpServiceServer()
 }
 
@@ -172,6 +325,36 @@ func (UnimplementedGcpServiceServer) Exist(context.Context, *ObjectKey) (*ExistR
 func (UnimplementedGcpServiceServer) List(context.Context, *Dir) (*ListResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
 }
+func (UnimplementedGcpServiceServer) ListObjects(context.Context, *Dir) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListObjects not implemented")
+}
+func (UnimplementedGcpServiceServer) ListStream(*Dir, GcpService_ListStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListStream not implemented")
+}
+func (UnimplementedGcpServiceServer) DeletePrefix(context.Context, *Dir) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePrefix not implemented")
+}
+func (UnimplementedGcpServiceServer) ListVersions(context.Context, *ObjectKey) (*VersionList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedGcpServiceServer) GetVersion(context.Context, *ObjectKey) (*File, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVersion not implemented")
+}
+func (UnimplementedGcpServiceServer) RestoreVersion(context.Context, *ObjectKey) (*Url, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (UnimplementedGcpServiceServer) DeleteVersion(context.Context, *ObjectKey) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteVersion not implemented")
+}
+func (UnimplementedGcpServiceServer) BatchDelete(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchDelete not implemented")
+}
+func (UnimplementedGcpServiceServer) BatchExist(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchExist not implemented")
+}
+func (UnimplementedGcpServiceServer) BatchStat(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchStat not implemented")
+}
 func (UnimplementedGcpServiceServer) mustEmbedUnimplementedGcpServiceServer() {}
 
 // UnsafeGcpServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -329,6 +512,189 @@ func _GcpService_List_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _GcpService_ListObjects_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Dir)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).ListObjects(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/ListObjects",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).ListObjects(ctx, req.(*Dir))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_ListStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Dir)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GcpServiceServer).ListStream(m, &gcpServiceListStreamServer{stream})
+}
+
+type GcpService_ListStreamServer interface {
+	Send(*ListItem) error
+	grpc.ServerStream
+}
+
+type gcpServiceListStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *gcpServiceListStreamServer) Send(m *ListItem) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _GcpService_DeletePrefix_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Dir)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).DeletePrefix(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/DeletePrefix",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).DeletePrefix(ctx, req.(*Dir))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ObjectKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/ListVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).ListVersions(ctx, req.(*ObjectKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_GetVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ObjectKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).GetVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/GetVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).GetVersion(ctx, req.(*ObjectKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ObjectKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/RestoreVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).RestoreVersion(ctx, req.(*ObjectKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_DeleteVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ObjectKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).DeleteVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/DeleteVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).DeleteVersion(ctx, req.(*ObjectKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_BatchDelete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).BatchDelete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/BatchDelete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).BatchDelete(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_BatchExist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).BatchExist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/BatchExist",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).BatchExist(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GcpService_BatchStat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GcpServiceServer).BatchStat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.GcpService/BatchStat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GcpServiceServer).BatchStat(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // GcpService_ServiceDesc is the grpc.ServiceDesc for GcpService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -368,7 +734,49 @@ var GcpService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "List",
 			Handler:    _GcpService_List_Handler,
 		},
+		{
+			MethodName: "ListObjects",
+			Handler:    _GcpService_ListObjects_Handler,
+		},
+		{
+			MethodName: "DeletePrefix",
+			Handler:    _GcpService_DeletePrefix_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _GcpService_ListVersions_Handler,
+		},
+		{
+			MethodName: "GetVersion",
+			Handler:    _GcpService_GetVersion_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _GcpService_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "DeleteVersion",
+			Handler:    _GcpService_DeleteVersion_Handler,
+		},
+		{
+			MethodName: "BatchDelete",
+			Handler:    _GcpService_BatchDelete_Handler,
+		},
+		{
+			MethodName: "BatchExist",
+			Handler:    _GcpService_BatchExist_Handler,
+		},
+		{
+			MethodName: "BatchStat",
+			Handler:    _GcpService_BatchStat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListStream",
+			Handler:       _GcpService_ListStream_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "grpc/proto/gcp.proto",
 }
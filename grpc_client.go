@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+const _grpc_DialTimeout = 10 * time.Second
+
+// GrpcClientOptions 為連線到 container 的 grpc 服務的設定，零值為不加密、等待連線建立最多 10 秒，不重試
+type GrpcClientOptions struct {
+	// 使用 TLS 連線，未設定 CAFile 及 TLSConfig 時以系統憑證驗證伺服器。
+	// 設定 TLSConfig、CAFile 或 CertFile 時也會使用 TLS
+	TLS bool `yaml:"tls"`
+	// 以此為基礎加上 CAFile 及 CertFile 的設定
+	TLSConfig *tls.Config `yaml:"-"`
+	// PEM 格式的 CA 憑證
+	CAFile string `yaml:"caFile"`
+	// mTLS 的 client 憑證及私鑰
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// 驗證伺服器憑證時使用的名稱，預設為 address 的 host
+	ServerName string `yaml:"serverName"`
+	// 每個 rpc 附加的憑證，例如 ApiKeyCredentials 或 oauth.TokenSource
+	PerRPCCredentials credentials.PerRPCCredentials `yaml:"-"`
+	// 不等待連線建立，無法連線時由之後的 rpc 回傳錯誤
+	NonBlocking bool `yaml:"nonBlocking"`
+	// 等待連線建立的時間，預設 10 秒，ctx 的期限較短時以 ctx 為準
+	DialTimeout time.Duration `yaml:"dialTimeout"`
+	// 連線閒置超過 KeepaliveTime 時送出 ping，KeepaliveTimeout 內沒有回應則中斷連線，0 表示不送
+	KeepaliveTime    time.Duration `yaml:"keepaliveTime"`
+	KeepaliveTimeout time.Duration `yaml:"keepaliveTimeout"`
+	// 每次 rpc 的逾時，重試時重新計算，不適用於 ListStream。0 表示只受 ctx 限制
+	CallTimeout time.Duration `yaml:"callTimeout"`
+	// 不為 nil 時重試冪等的 rpc
+	Retry *GrpcRetryPolicy `yaml:"retry"`
+	// 不為 nil 時服務持續失敗會斷路，rpc 直接回傳 ErrCircuitOpen
+	CircuitBreaker *GrpcCircuitBreaker `yaml:"circuitBreaker"`
+}
+
+func (opts *GrpcClientOptions) tlsConfig() (*tls.Config, error) {
+	if !opts.TLS && opts.TLSConfig == nil && opts.CAFile == "" && opts.CertFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca file")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if opts.ServerName != "" {
+		config.ServerName = opts.ServerName
+	}
+	return config, nil
+}
+
+func (opts *GrpcClientOptions) dialOptions(address string) ([]grpc.DialOption, error) {
+	creds := insecure.NewCredentials()
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if !opts.NonBlocking {
+		dialOpts = append(dialOpts, grpc.WithBlock(), grpc.WithReturnConnectionError())
+	}
+	if opts.PerRPCCredentials != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(opts.PerRPCCredentials))
+	}
+	if opts.KeepaliveTime > 0 {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                opts.KeepaliveTime,
+			Timeout:             opts.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	if caller := newGrpcCaller(address, opts); caller != nil {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(caller.unary),
+			grpc.WithChainStreamInterceptor(caller.stream),
+		)
+	}
+	return dialOpts, nil
+}
+
+// ApiKeyCredentials 以 x-api-key metadata 帶入 container 的 grpc 驗證使用的 api key，
+// AllowInsecure 為 false 時只能用於 TLS 連線
+type ApiKeyCredentials struct {
+	Key           string
+	AllowInsecure bool
+}
+
+func (c ApiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": c.Key}, nil
+}
+
+func (c ApiKeyCredentials) RequireTransportSecurity() bool {
+	return !c.AllowInsecure
+}
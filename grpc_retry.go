@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"math/rand"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen 斷路器開啟時 rpc 不會送出，直接回傳此錯誤
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	_retry_MaxAttempts    = 3
+	_retry_InitialBackoff = 100 * time.Millisecond
+	_retry_MaxBackoff     = 2 * time.Second
+
+	_breaker_ConsecutiveFailures = 5
+	_breaker_OpenTimeout         = 30 * time.Second
+)
+
+// 只有重複呼叫不會改變結果的 rpc 會重試，SaveFile、Delete 及 BatchDelete 等不重試
+var idempotentMethods = map[string]bool{
+	"GetFile":        true,
+	"GetDownloadUrl": true,
+	"GetSignedUrl":   true,
+	"GetAccessToken": true,
+	"Exist":          true,
+	"List":           true,
+	"ListObjects":    true,
+	"ListVersions":   true,
+	"GetVersion":     true,
+	"BatchExist":     true,
+	"BatchStat":      true,
+}
+
+// GrpcRetryPolicy 冪等的 rpc 回傳 Unavailable 或超過 CallTimeout 時重試，等待時間每次加倍並加上隨機的抖動
+type GrpcRetryPolicy struct {
+	// 包含第一次呼叫，預設 3
+	MaxAttempts int `yaml:"maxAttempts"`
+	// 預設 100ms
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	// 預設 2s
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// GrpcCircuitBreaker 連續失敗 ConsecutiveFailures 次後斷路，OpenTimeout 後放行一個 rpc 測試服務是否恢復。
+// 只有 Unavailable 及 DeadlineExceeded 視為失敗；服務對單一請求的錯誤 (例如刪除不存在的 key) 回傳 Internal，不表示服務異常
+type GrpcCircuitBreaker struct {
+	// 預設 5
+	ConsecutiveFailures uint32 `yaml:"consecutiveFailures"`
+	// 預設 30s
+	OpenTimeout time.Duration `yaml:"openTimeout"`
+	// 狀態改變時呼叫，例如記錄 log，狀態為 closed、half-open 及 open
+	OnStateChange func(address string, from, to string) `yaml:"-"`
+}
+
+func (b *GrpcCircuitBreaker) newBreaker(address string) *gobreaker.CircuitBreaker {
+	failures := b.ConsecutiveFailures
+	if failures == 0 {
+		failures = _breaker_ConsecutiveFailures
+	}
+	timeout := b.OpenTimeout
+	if timeout <= 0 {
+		timeout = _breaker_OpenTimeout
+	}
+	settings := gobreaker.Settings{
+		Name:    address,
+		Timeout: timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= failures
+		},
+		IsSuccessful: func(err error) bool {
+			switch status.Code(err) {
+			case codes.Unavailable, codes.DeadlineExceeded:
+				return false
+			}
+			return true
+		},
+	}
+	if b.OnStateChange != nil {
+		settings.OnStateChange = func(name string, from, to gobreaker.State) {
+			b.OnStateChange(name, from.String(), to.String())
+		}
+	}
+	return gobreaker.NewCircuitBreaker(settings)
+}
+
+// grpcCaller 依 GrpcClientOptions 為每個 rpc 加上逾時、重試及斷路器
+type grpcCaller struct {
+	address     string
+	callTimeout time.Duration
+	retry       *GrpcRetryPolicy
+	breaker     *gobreaker.CircuitBreaker
+}
+
+func newGrpcCaller(address string, opts *GrpcClientOptions) *grpcCaller {
+	if opts.CallTimeout <= 0 && opts.Retry == nil && opts.CircuitBreaker == nil {
+		return nil
+	}
+	c := &grpcCaller{
+		address:     address,
+		callTimeout: opts.CallTimeout,
+		retry:       opts.Retry,
+	}
+	if opts.CircuitBreaker != nil {
+		c.breaker = opts.CircuitBreaker.newBreaker(address)
+	}
+	return c
+}
+
+func (c *grpcCaller) unary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	attempts, backoff, maxBackoff := 1, _retry_InitialBackoff, _retry_MaxBackoff
+	if c.retry != nil && idempotentMethods[path.Base(method)] {
+		attempts = _retry_MaxAttempts
+		if c.retry.MaxAttempts > 0 {
+			attempts = c.retry.MaxAttempts
+		}
+		if c.retry.InitialBackoff > 0 {
+			backoff = c.retry.InitialBackoff
+		}
+		if c.retry.MaxBackoff > 0 {
+			maxBackoff = c.retry.MaxBackoff
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.execute(func() error {
+			callCtx := ctx
+			if c.callTimeout > 0 {
+				var cancel context.CancelFunc
+				callCtx, cancel = context.WithTimeout(ctx, c.callTimeout)
+				defer cancel()
+			}
+			return invoker(callCtx, method, req, reply, cc, opts...)
+		})
+		if err == nil || attempt >= attempts || !retryable(ctx, err) {
+			return err
+		}
+		// 等待 backoff 的一半到全部之間的隨機時間，避免多個 client 同時重試
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// stream ListStream 的時間依資料量而定，不套用 CallTimeout 也不重試
+func (c *grpcCaller) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	var cs grpc.ClientStream
+	err := c.execute(func() (err error) {
+		cs, err = streamer(ctx, desc, cc, method, opts...)
+		return err
+	})
+	return cs, err
+}
+
+func (c *grpcCaller) execute(call func() error) error {
+	if c.breaker == nil {
+		return call()
+	}
+	_, err := c.breaker.Execute(func() (any, error) {
+		return nil, call()
+	})
+	if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+		return errors.Wrap(ErrCircuitOpen, c.address)
+	}
+	return err
+}
+
+// retryable 只有單次呼叫逾時才重試 DeadlineExceeded，呼叫端的 ctx 結束時不重試
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
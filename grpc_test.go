@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/94peter/storage/grpc/pb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// emptyListServer 串流回傳沒有 prefix 也沒有 object 的項目
+type emptyListServer struct {
+	pb.UnimplementedGcpServiceServer
+}
+
+func (emptyListServer) ListStream(dir *pb.Dir, stream pb.GcpService_ListStreamServer) error {
+	return stream.Send(&pb.ListItem{})
+}
+
+func newTestGrpcStorage(t *testing.T, srv pb.GcpServiceServer, opts ...grpc.DialOption) GrpcGcpStorage {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterGcpServiceServer(server, srv)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.DialContext(context.Background(), "bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewGrpcGcpStorageWithConn(context.Background(), conn, "test")
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestGrpcEmptyListItem(t *testing.T) {
+	sto := newTestGrpcStorage(t, emptyListServer{})
+	it := sto.Objects("", nil)
+	defer it.Stop()
+	if _, err := it.Next(); err == nil || err == IteratorDone {
+		t.Fatalf("Next = %v, want error", err)
+	}
+}
+
+// failingBatchServer 第一個 BatchDelete rpc 成功，之後的 rpc 都失敗
+type failingBatchServer struct {
+	pb.UnimplementedGcpServiceServer
+	calls int
+}
+
+func (s *failingBatchServer) BatchDelete(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	s.calls++
+	if s.calls > 1 {
+		return nil, status.Error(codes.Unavailable, "backend down")
+	}
+	rsp := &pb.BatchResponse{}
+	for _, key := range req.Keys {
+		rsp.Results = append(rsp.Results, &pb.BatchResult{Key: key})
+	}
+	return rsp, nil
+}
+
+func TestGrpcBatchPartialFailure(t *testing.T) {
+	sto := newTestGrpcStorage(t, &failingBatchServer{})
+	keys := make([]string, _grpc_BatchSize+10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d", i)
+	}
+	results, err := sto.(BatchStorage).BatchDelete(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(keys) {
+		t.Fatalf("len(results) = %d, want %d", len(results), len(keys))
+	}
+	for i, r := range results {
+		if r.Key != keys[i] {
+			t.Fatalf("results[%d].Key = %q, want %q", i, r.Key, keys[i])
+		}
+		if sent := i < _grpc_BatchSize; sent != (r.Err == nil) {
+			t.Fatalf("results[%d].Err = %v", i, r.Err)
+		}
+	}
+
+	if _, err := sto.(BatchStorage).BatchDelete([]string{"a"}); err == nil {
+		t.Fatal("BatchDelete with failing first rpc should return error")
+	}
+}
+
+// codeServer Delete 一律回傳 code
+type codeServer struct {
+	pb.UnimplementedGcpServiceServer
+	code codes.Code
+}
+
+func (s *codeServer) Delete(ctx context.Context, key *pb.ObjectKey) (*emptypb.Empty, error) {
+	return nil, status.Error(s.code, "delete failed")
+}
+
+// TestGrpcBreakerIgnoresRequestErrors 單一請求的錯誤不會開啟斷路器，Unavailable 才會
+func TestGrpcBreakerIgnoresRequestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		code codes.Code
+		open bool
+	}{
+		{codes.Internal, false},
+		{codes.NotFound, false},
+		{codes.InvalidArgument, false},
+		{codes.Unavailable, true},
+	} {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			caller := newGrpcCaller("bufnet", &GrpcClientOptions{CircuitBreaker: &GrpcCircuitBreaker{ConsecutiveFailures: 3}})
+			sto := newTestGrpcStorage(t, &codeServer{code: tt.code}, grpc.WithChainUnaryInterceptor(caller.unary))
+			for i := 0; i < 5; i++ {
+				sto.Delete("missing.txt")
+			}
+			if err := sto.Delete("missing.txt"); errors.Is(err, ErrCircuitOpen) != tt.open {
+				t.Fatalf("Delete after 6 %s errors = %v, want circuit open %v", tt.code, err, tt.open)
+			}
+		})
+	}
+}
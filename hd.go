@@ -5,65 +5,570 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type HdStorage interface {
 	Storage
+	ConditionalStorage
+	VersionedStorage
+	ObjectLister
+	PrefixDeleter
+	ObjectReader
+	// FullPath 回傳 key 的檔案路徑，key 不合法或會跳出根目錄時回傳空字串
 	FullPath(key string) string
+	// ResolvePath 與 FullPath 相同，key 不合法時回傳 ErrInvalidKey
+	ResolvePath(key string) (string, error)
+}
+
+const (
+	_hd_MetaDir    = ".storage"
+	_hd_GenDir     = "gen"
+	_hd_VersionDir = "versions"
+	_hd_LockDir    = "lock"
+	_hd_TmpDir     = "tmp"
+	_hd_MaxRetry   = 3
+	_hd_LockWait   = 10 * time.Millisecond
+	_hd_LockExpire = 5 * time.Second
+	// 持有者更新 lock 檔時間的間隔，需遠小於 _hd_LockExpire
+	_hd_LockRefresh = time.Second
+	_hd_LockTimeout = 30 * time.Second
+)
+
+type HdOptions struct {
+	// 檔案權限，預設 0644
+	FileMode os.FileMode `yaml:"fileMode"`
+	// 目錄權限，預設 0755
+	DirMode os.FileMode `yaml:"dirMode"`
+	// 建立的檔案及目錄會移除 Umask 中的權限，不受 process umask 影響
+	Umask os.FileMode `yaml:"umask"`
+	// 不為 nil 時變更建立的檔案及目錄的擁有者
+	Uid *int `yaml:"uid"`
+	Gid *int `yaml:"gid"`
+	// 覆寫或刪除檔案時保留最近幾個舊版本，0 表示不保留
+	KeepVersions int `yaml:"keepVersions"`
+	// 內容相同的檔案只儲存一份，見 NewDedupHdStorage
+	Dedup bool `yaml:"dedup"`
+	// 依 key 的 hash 分層存放檔案，例如 2 層時 key 存放於 "ab/cd/key"，避免單一目錄下檔案過多。
+	// 已有資料的目錄變更分層設定前需先以 ReshardHd 搬移
+	ShardLevels int `yaml:"shardLevels"`
+	// 每層目錄名稱的字元數，預設 2
+	ShardWidth int `yaml:"shardWidth"`
+	// 所有檔案大小總和及檔案數量上限，超過時寫入回傳 ErrQuotaExceeded，0 表示不限制
+	MaxBytes int64 `yaml:"maxBytes"`
+	MaxFiles int64 `yaml:"maxFiles"`
+	// 寫入前檢查檔案系統剩餘空間，寫入後需至少保留 MinFreeBytes
+	MinFreeBytes uint64 `yaml:"minFreeBytes"`
 }
 
 func NewHdStorage(path string) HdStorage {
+	return NewHdStorageWithOptions(path, HdOptions{})
+}
+
+// NewVersionedHdStorage 覆寫或刪除檔案時保留最近 keep 個舊版本
+func NewVersionedHdStorage(path string, keep int) HdStorage {
+	return NewHdStorageWithOptions(path, HdOptions{KeepVersions: keep})
+}
+
+func NewHdStorageWithOptions(path string, opts HdOptions) HdStorage {
+	return newHd(path, opts)
+}
+
+func newHd(path string, opts HdOptions) *hd {
 	if !strings.HasSuffix(path, "/") {
 		path = path + "/"
 	}
-	return &hd{Path: path}
+	if opts.FileMode == 0 {
+		opts.FileMode = 0644
+	}
+	if opts.DirMode == 0 {
+		opts.DirMode = 0755
+	}
+	if opts.ShardWidth <= 0 {
+		opts.ShardWidth = _hd_ShardWidth
+	}
+	return &hd{Path: path, opts: opts, usage: &hdUsage{}}
 }
 
 type hd struct {
-	Path string
+	Path  string
+	opts  HdOptions
+	usage *hdUsage
 }
 
-func (hd *hd) getAbsFilePath(filePath string) string {
-	absFilePath, _ := filepath.Abs(hd.Path + filePath)
-	return absFilePath
+func (hd *hd) fileMode() os.FileMode {
+	return hd.opts.FileMode.Perm() &^ hd.opts.Umask
 }
 
-func (hd *hd) Save(fp string, file []byte) (string, error) {
-	absFilePath := hd.getAbsFilePath(fp)
-	err := hd.mkdir(absFilePath)
+func (hd *hd) dirMode() os.FileMode {
+	return hd.opts.DirMode.Perm() &^ hd.opts.Umask
+}
+
+// chown 依設定變更擁有者，未設定時不做任何事
+func (hd *hd) chown(path string) error {
+	if hd.opts.Uid == nil && hd.opts.Gid == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if hd.opts.Uid != nil {
+		uid = *hd.opts.Uid
+	}
+	if hd.opts.Gid != nil {
+		gid = *hd.opts.Gid
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// getAbsFilePath 檢查 key 並回傳其絕對路徑，key 不可跳出根目錄
+func (hd *hd) getAbsFilePath(filePath string) (string, error) {
+	if err := validateKey(filePath); err != nil {
+		return "", err
+	}
+	return hd.resolve(filePath)
+}
+
+// getAbsDirPath 與 getAbsFilePath 相同，但允許根目錄及以 "/" 結尾的目錄
+func (hd *hd) getAbsDirPath(dir string) (string, error) {
+	if err := validatePrefix(dir); err != nil {
+		return "", err
+	}
+	return hd.resolve(dir)
+}
+
+func (hd *hd) resolve(key string) (string, error) {
+	if key == _hd_MetaDir || strings.HasPrefix(key, _hd_MetaDir+"/") {
+		return "", errors.Wrapf(ErrInvalidKey, "reserved: %q", key)
+	}
+	absPath, err := filepath.Abs(hd.filePath(key))
 	if err != nil {
 		return "", err
 	}
-	return absFilePath, os.WriteFile(absFilePath, file, 0644)
+	absRoot, err := filepath.Abs(hd.Path)
+	if err != nil {
+		return "", err
+	}
+	// 以解析 symlink 後的路徑確認沒有跳出根目錄
+	resolvedRoot, err := evalExistingSymlinks(absRoot)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := evalExistingSymlinks(absPath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Wrapf(ErrInvalidKey, "escape root: %q", key)
+	}
+	return absPath, nil
+}
+
+// evalExistingSymlinks 解析路徑中已存在部分的 symlink，不存在的部分原樣接回
+func evalExistingSymlinks(path string) (string, error) {
+	existing := path
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		// 指向不存在目標的 symlink，寫入時會在根目錄外建立檔案
+		if _, lerr := os.Lstat(existing); lerr == nil {
+			return "", errors.Wrapf(ErrInvalidKey, "dangling symlink: %s", existing)
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path, nil
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+}
+
+// filePath 回傳 key 在根目錄下的路徑，呼叫前 key 必須已經檢查過
+func (hd *hd) filePath(key string) string {
+	return filepath.Join(hd.Path, hd.shardDir(key), filepath.FromSlash(key))
+}
+
+func (hd *hd) getMetaPath(kind string, filePath string) string {
+	return filepath.Join(hd.Path, _hd_MetaDir, kind, hd.shardDir(filePath), filepath.FromSlash(filePath))
+}
+
+func (hd *hd) Save(fp string, file []byte) (string, error) {
+	return hd.SaveByReader(fp, bytes.NewReader(file))
 }
 
 func (hd *hd) SaveByReader(fp string, reader io.Reader) (string, error) {
-	absFilePath := hd.getAbsFilePath(fp)
-	err := hd.mkdir(absFilePath)
+	if _, err := hd.getAbsFilePath(fp); err != nil {
+		return "", err
+	}
+	unlock, err := hd.lock(fp)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return hd.save(fp, reader)
+}
+
+func (hd *hd) save(fp string, reader io.Reader) (string, error) {
+	absFilePath, err := hd.getAbsFilePath(fp)
+	if err != nil {
+		return "", err
+	}
+	err = hd.mkdir(absFilePath)
+	if err != nil {
+		return "", err
+	}
+	err = hd.archive(fp)
+	if err != nil {
+		return "", err
+	}
+	err = hd.writeObject(fp, absFilePath, reader, false)
+	if err != nil {
+		return "", err
+	}
+	return absFilePath, hd.nextGeneration(fp)
+}
+
+// writeObject 寫入物件內容，dedup 模式時改為連結到內容相同的 blob
+func (hd *hd) writeObject(fp string, absFilePath string, reader io.Reader, noReplace bool) error {
+	reader, done, err := hd.quotaReader(absFilePath, reader)
 	if err != nil {
+		return err
+	}
+	if hd.opts.Dedup {
+		err = hd.writeDedup(fp, absFilePath, reader, noReplace)
+	} else {
+		err = hd.writeAtomic(absFilePath, reader, noReplace)
+	}
+	done(err)
+	return err
+}
+
+func (hd *hd) SaveIfNotExists(fp string, file []byte) (string, error) {
+	absFilePath, err := hd.getAbsFilePath(fp)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := hd.lock(fp)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	err = hd.mkdir(absFilePath)
+	if err != nil {
+		return "", err
+	}
+	err = hd.writeObject(fp, absFilePath, bytes.NewReader(file), true)
+	if os.IsExist(err) {
+		return "", errors.Wrapf(ErrPreconditionFailed, "file exist: %s", absFilePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return absFilePath, hd.nextGeneration(fp)
+}
+
+// writeAtomic 先寫入 .storage/tmp 下的暫存檔並 fsync，再 rename 到 absPath，讀取端只會看到完整的檔案。
+// noReplace 為 true 時以 hard link 取代 rename，absPath 已存在時回傳 os.ErrExist
+func (hd *hd) writeAtomic(absPath string, reader io.Reader, noReplace bool) error {
+	tmpPath, err := hd.writeTemp(filepath.Base(absPath), reader)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	return hd.place(tmpPath, absPath, noReplace)
+}
+
+// writeTemp 將 reader 寫入 .storage/tmp 下的暫存檔並 fsync，由呼叫端負責刪除
+func (hd *hd) writeTemp(name string, reader io.Reader) (string, error) {
+	tmpDir := filepath.Join(hd.Path, _hd_MetaDir, _hd_TmpDir)
+	if err := hd.mkdirAll(tmpDir); err != nil {
 		return "", err
 	}
-	f, err := os.OpenFile(absFilePath, os.O_WRONLY|os.O_CREATE, 0666)
+	f, err := os.CreateTemp(tmpDir, name+".*")
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
+	tmpPath := f.Name()
+
 	_, err = io.Copy(f, reader)
+	if err == nil {
+		err = f.Chmod(hd.fileMode())
+	}
+	if err == nil {
+		err = hd.chown(tmpPath)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
+		os.Remove(tmpPath)
 		return "", err
 	}
-	return absFilePath, nil
+	return tmpPath, nil
+}
+
+// place 將暫存檔 rename 或 hard link 到 absPath
+func (hd *hd) place(tmpPath string, absPath string, noReplace bool) error {
+	for retry := 0; ; retry++ {
+		var err error
+		if noReplace {
+			err = os.Link(tmpPath, absPath)
+		} else {
+			err = os.Rename(tmpPath, absPath)
+		}
+		// 目錄可能在寫入期間被 pruneDirs 刪除
+		if os.IsNotExist(err) && retry < _hd_MaxRetry {
+			if err = hd.mkdir(absPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return syncDir(filepath.Dir(absPath))
+	}
+}
+
+func (hd *hd) SaveIfMatch(fp string, file []byte, generation int64) (string, error) {
+	if _, err := hd.getAbsFilePath(fp); err != nil {
+		return "", err
+	}
+	unlock, err := hd.lock(fp)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	current, err := hd.generation(fp)
+	if err != nil {
+		return "", err
+	}
+	if current != generation {
+		return "", errors.Wrapf(ErrPreconditionFailed, "generation not match: %d != %d", current, generation)
+	}
+	return hd.save(fp, bytes.NewReader(file))
+}
+
+func (hd *hd) DeleteIfMatch(fp string, generation int64) error {
+	if _, err := hd.getAbsFilePath(fp); err != nil {
+		return err
+	}
+	unlock, err := hd.lock(fp)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := hd.generation(fp)
+	if err != nil {
+		return err
+	}
+	if current != generation {
+		return errors.Wrapf(ErrPreconditionFailed, "generation not match: %d != %d", current, generation)
+	}
+	return hd.delete(fp)
+}
+
+func (hd *hd) Generation(fp string) (int64, error) {
+	if _, err := hd.getAbsFilePath(fp); err != nil {
+		return 0, err
+	}
+	return hd.generation(fp)
+}
+
+// generation 紀錄在 .storage/gen 下的 sidecar 檔案，檔案存在但沒有 sidecar 時視為 1
+func (hd *hd) generation(fp string) (int64, error) {
+	exist, err := fileExist(hd.filePath(fp))
+	if err != nil {
+		return 0, err
+	}
+	if !exist {
+		return 0, nil
+	}
+	data, err := os.ReadFile(hd.getMetaPath(_hd_GenDir, fp))
+	if os.IsNotExist(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+// 與 gcs 相同以時間作為 generation，避免刪除後重建的物件沿用舊的 generation
+func (hd *hd) nextGeneration(fp string) error {
+	current, err := hd.generation(fp)
+	if err != nil {
+		return err
+	}
+	next := time.Now().UnixMicro()
+	if next <= current {
+		next = current + 1
+	}
+	genPath := hd.getMetaPath(_hd_GenDir, fp)
+	if err = hd.mkdir(genPath); err != nil {
+		return err
+	}
+	return hd.writeAtomic(genPath, strings.NewReader(strconv.FormatInt(next, 10)), false)
+}
+
+// lock 以 O_EXCL 建立 lock 檔，讓不同 process 對同一個 key 的寫入互斥。
+// 持有期間定期更新 lock 檔的時間，超過 _hd_LockExpire 沒有更新的 lock 檔視為持有者異常結束留下的。
+// lock 檔中寫入持有者的 token，只移除自己的 lock，等待超過 _hd_LockTimeout 時回傳錯誤
+func (hd *hd) lock(fp string) (unlock func(), err error) {
+	lockPath := hd.getMetaPath(_hd_LockDir, fp)
+	lockRoot := filepath.Join(hd.Path, _hd_MetaDir, _hd_LockDir)
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(_hd_LockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, hd.fileMode())
+		if err == nil {
+			_, err = f.WriteString(token)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return hd.holdLock(lockPath, lockRoot, token), nil
+		}
+		if os.IsNotExist(err) {
+			if err = hd.mkdir(lockPath); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if removeStaleLock(lockPath) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("hd: lock %q timeout after %s", fp, _hd_LockTimeout)
+		}
+		time.Sleep(_hd_LockWait)
+	}
+}
+
+// holdLock 在 unlock 前定期更新 lock 檔的時間
+func (hd *hd) holdLock(lockPath, lockRoot, token string) (unlock func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(_hd_LockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				os.Chtimes(lockPath, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		if owner, err := os.ReadFile(lockPath); err == nil && string(owner) == token {
+			os.Remove(lockPath)
+		}
+		pruneDirs(filepath.Dir(lockPath), lockRoot)
+	}
+}
+
+// removeStaleLock 移除超過 _hd_LockExpire 沒有更新的 lock 檔
+func removeStaleLock(lockPath string) bool {
+	owner, err := os.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(lockPath)
+	if err != nil || time.Since(info.ModTime()) <= _hd_LockExpire {
+		return false
+	}
+	// 讀取 token 後 lock 可能已被其他人移除並重新取得
+	if current, err := os.ReadFile(lockPath); err != nil || !bytes.Equal(current, owner) {
+		return false
+	}
+	return os.Remove(lockPath) == nil
+}
+
+func (hd *hd) NewRangeReader(key string, offset, length int64) (io.ReadCloser, error) {
+	absFilePath, err := hd.getAbsFilePath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(absFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
 }
 
 func (hd *hd) FullPath(key string) string {
+	path, err := hd.getAbsFilePath(key)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+func (hd *hd) ResolvePath(key string) (string, error) {
 	return hd.getAbsFilePath(key)
 }
 
 func (hd *hd) Delete(filePath string) error {
-	absFilePath := hd.getAbsFilePath(filePath)
+	if _, err := hd.getAbsFilePath(filePath); err != nil {
+		return err
+	}
+	unlock, err := hd.lock(filePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return hd.delete(filePath)
+}
+
+func (hd *hd) delete(filePath string) error {
+	absFilePath, err := hd.getAbsFilePath(filePath)
+	if err != nil {
+		return err
+	}
 	exist, err := fileExist(absFilePath)
 	if err != nil {
 		return err
@@ -71,29 +576,118 @@ func (hd *hd) Delete(filePath string) error {
 	if !exist {
 		return errors.New("file not exist: " + absFilePath)
 	}
-	return os.Remove(absFilePath)
+	err = hd.archive(filePath)
+	if err != nil {
+		return err
+	}
+	return hd.removeCurrent(filePath)
+}
+
+func (hd *hd) removeCurrent(filePath string) error {
+	absFilePath := hd.filePath(filePath)
+	info, err := os.Stat(absFilePath)
+	if err == nil {
+		if err = os.Remove(absFilePath); err == nil {
+			hd.addUsage(-info.Size(), -1)
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	pruneDirs(filepath.Dir(absFilePath), filepath.Clean(hd.Path))
+
+	genPath := hd.getMetaPath(_hd_GenDir, filePath)
+	err = os.Remove(genPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	pruneDirs(filepath.Dir(genPath), filepath.Join(hd.Path, _hd_MetaDir, _hd_GenDir))
+	return hd.releaseRef(filePath)
+}
+
+// DeletePrefix 刪除 prefix 之下所有的檔案，並移除因此變空的目錄
+func (hd *hd) DeletePrefix(prefix string) error {
+	if prefix == "" {
+		return errors.Wrap(ErrInvalidKey, "prefix can not be empty")
+	}
+	if _, err := hd.getAbsDirPath(prefix); err != nil {
+		return err
+	}
+	var keys []string
+	err := hd.walkEntries(prefix, "", func(object *ObjectInfo) error {
+		keys = append(keys, object.Key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = hd.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneDirs 由 dir 往上刪除空目錄，不會刪除 stop 本身
+func pruneDirs(dir string, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
 }
 
 func (hd *hd) Get(fp string) ([]byte, error) {
-	absFilePath := hd.getAbsFilePath(fp)
+	absFilePath, err := hd.getAbsFilePath(fp)
+	if err != nil {
+		return nil, err
+	}
 	return os.ReadFile(absFilePath)
 }
 
 func (hd *hd) mkdir(absPath string) error {
-	dir := filepath.Dir(absPath)
-	exist, _ := fileExist(dir)
+	return hd.mkdirAll(filepath.Dir(absPath))
+}
 
-	if !exist {
-		err := os.MkdirAll(dir, 0766)
-		if err != nil {
+// mkdirAll 與 os.MkdirAll 相同，但新建立的目錄會套用 DirMode 及擁有者設定
+func (hd *hd) mkdirAll(dir string) error {
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err = hd.mkdirAll(parent); err != nil {
 			return err
 		}
 	}
-	return nil
+	err = os.Mkdir(dir, hd.dirMode())
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Mkdir 的權限會受 process umask 影響
+	if err = os.Chmod(dir, hd.dirMode()); err != nil {
+		return err
+	}
+	return hd.chown(dir)
 }
 
 func (hd *hd) FileExist(fp string) (bool, error) {
-	absFilePath := hd.getAbsFilePath(fp)
+	absFilePath, err := hd.getAbsDirPath(fp)
+	if err != nil {
+		return false, err
+	}
 	exist, err := fileExist(absFilePath)
 	if err != nil {
 		return false, err
@@ -101,24 +695,87 @@ func (hd *hd) FileExist(fp string) (bool, error) {
 	return exist, nil
 }
 
-func (hd *hd) List(dir string) ([]string, error) {
-	absDir := hd.getAbsFilePath(dir)
-	files, err := os.ReadDir(absDir)
+func (hd *hd) BatchDelete(keys []string) ([]BatchResult, error) {
+	return batchDelete(hd, keys), nil
+}
+
+func (hd *hd) BatchExist(keys []string) ([]BatchResult, error) {
+	return batchExist(hd, keys), nil
+}
+
+func (hd *hd) BatchStat(keys []string) ([]BatchResult, error) {
+	return batchStat(keys, hd.stat), nil
+}
+
+// stat 持有 key 的 lock 讀取檔案資訊及 generation，避免與同時進行的寫入取得不同版本的值。
+// 物件不存在時回傳 nil, nil
+func (hd *hd) stat(key string) (*ObjectInfo, error) {
+	if _, err := hd.getAbsFilePath(key); err != nil {
+		return nil, err
+	}
+	unlock, err := hd.lock(key)
 	if err != nil {
 		return nil, err
 	}
-	var result []string
-	for _, f := range files {
-		if f.IsDir() {
-			result = append(result, strAppend(dir, f.Name(), "/"))
-		} else {
-			result = append(result, strAppend(dir, f.Name()))
-		}
+	defer unlock()
+	return hd.statKey(key)
+}
 
+// statKey 只讀取 key 所在的分層目錄，不持有 lock，呼叫前需確認 key 合法
+func (hd *hd) statKey(key string) (*ObjectInfo, error) {
+	info, err := os.Stat(hd.filePath(key))
+	// 上層路徑是檔案時為 ENOTDIR
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) || err == nil && info.IsDir() {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	generation, err := hd.generation(key)
+	if err != nil {
+		return nil, err
 	}
+	return &ObjectInfo{
+		Key:        key,
+		Size:       info.Size(),
+		Updated:    info.ModTime(),
+		Generation: generation,
+	}, nil
+}
+
+func (hd *hd) List(dir string) ([]string, error) {
+	if _, err := hd.getAbsDirPath(dir); err != nil {
+		return nil, err
+	}
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir = dir + "/"
+	}
+	var result []string
+	err := hd.walkEntries(dir, "/", func(object *ObjectInfo) error {
+		result = append(result, object.name())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(result)
 	return result, nil
 }
 
+// syncDir 確保 rename 後的目錄項目寫入磁碟
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// 部分檔案系統不支援對目錄 fsync
+	if err = d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
+
 func fileExist(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
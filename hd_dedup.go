@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_hd_BlobDir = "blobs"
+	_hd_RefDir  = "refs"
+	// 超過此時間的暫存檔視為寫入中斷的殘留
+	_hd_TmpExpire = time.Hour
+)
+
+type DedupHdStorage interface {
+	HdStorage
+	// GC 刪除沒有任何 key 或版本參照的 blob 及殘留的暫存檔，回傳刪除的 blob 數量
+	GC() (int, error)
+}
+
+// NewDedupHdStorage 以 SHA-256 儲存內容，相同內容只保留一個 blob，key 為指向 blob 的 hard link。
+// blob 的 link 數即為參照數，Delete 時若已無其他參照會一併刪除 blob。
+func NewDedupHdStorage(path string, opts HdOptions) DedupHdStorage {
+	opts.Dedup = true
+	return newHd(path, opts)
+}
+
+func (hd *hd) getBlobPath(sum string) string {
+	return filepath.Join(hd.Path, _hd_MetaDir, _hd_BlobDir, sum[:2], sum)
+}
+
+// writeDedup 寫入暫存檔時同時計算 SHA-256，blob 不存在時以暫存檔建立，再將 key 連結到 blob
+func (hd *hd) writeDedup(fp string, absFilePath string, reader io.Reader, noReplace bool) error {
+	hash := sha256.New()
+	tmpPath, err := hd.writeTemp(filepath.Base(absFilePath), io.TeeReader(reader, hash))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	blobPath := hd.getBlobPath(sum)
+	if err = hd.place(tmpPath, blobPath, true); err != nil && !os.IsExist(err) {
+		return err
+	}
+	// blob 已存在時改用指向 blob 的暫存連結，讓 key 以 rename 原子地替換
+	if os.IsExist(err) {
+		if err = os.Remove(tmpPath); err != nil {
+			return err
+		}
+		if err = os.Link(blobPath, tmpPath); err != nil {
+			return err
+		}
+	}
+
+	refPath := hd.getMetaPath(_hd_RefDir, fp)
+	oldSum, err := os.ReadFile(refPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = hd.place(tmpPath, absFilePath, noReplace); err != nil {
+		return err
+	}
+	if err = hd.writeAtomic(refPath, strings.NewReader(sum), false); err != nil {
+		return err
+	}
+	// 被覆寫的內容若已沒有其他參照則刪除
+	if len(oldSum) > 0 && string(oldSum) != sum {
+		_, err = hd.removeUnreferencedBlob(hd.getBlobPath(string(oldSum)))
+	}
+	return err
+}
+
+// releaseRef 在 key 刪除後移除參照紀錄，blob 已沒有其他連結時一併刪除
+func (hd *hd) releaseRef(fp string) error {
+	refPath := hd.getMetaPath(_hd_RefDir, fp)
+	data, err := os.ReadFile(refPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(refPath); err != nil {
+		return err
+	}
+	pruneDirs(filepath.Dir(refPath), filepath.Join(hd.Path, _hd_MetaDir, _hd_RefDir))
+	_, err = hd.removeUnreferencedBlob(hd.getBlobPath(string(data)))
+	return err
+}
+
+func (hd *hd) removeUnreferencedBlob(blobPath string) (bool, error) {
+	info, err := os.Stat(blobPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	links, ok := linkCount(info)
+	if !ok {
+		return false, errors.New("hard link count is not supported on this platform")
+	}
+	if links > 1 {
+		return false, nil
+	}
+	if err = os.Remove(blobPath); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	pruneDirs(filepath.Dir(blobPath), filepath.Join(hd.Path, _hd_MetaDir, _hd_BlobDir))
+	return true, nil
+}
+
+func (hd *hd) GC() (int, error) {
+	removed := 0
+	blobDir := filepath.Join(hd.Path, _hd_MetaDir, _hd_BlobDir)
+	err := filepath.WalkDir(blobDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ok, err := hd.removeUnreferencedBlob(path)
+		if ok {
+			removed++
+		}
+		return err
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	tmpDir := filepath.Join(hd.Path, _hd_MetaDir, _hd_TmpDir)
+	files, err := os.ReadDir(tmpDir)
+	if err != nil && !os.IsNotExist(err) {
+		return removed, err
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err == nil && time.Since(info.ModTime()) > _hd_TmpExpire {
+			os.Remove(filepath.Join(tmpDir, f.Name()))
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func (hd *hd) ListObjects(prefix string, opts *ListOptions) (*ListPage, error) {
+	if _, err := hd.getAbsDirPath(prefix); err != nil {
+		return nil, err
+	}
+	match, err := opts.matcher(true)
+	if err != nil {
+		return nil, err
+	}
+	var objects []ObjectInfo
+	var prefixes []string
+	err = hd.walkRange(prefix, opts, func(object *ObjectInfo) error {
+		if !match(object) {
+			return nil
+		}
+		if object.Prefix != "" {
+			prefixes = append(prefixes, object.Prefix)
+		} else {
+			objects = append(objects, *object)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paginate(objects, prefixes, opts), nil
+}
+
+func (hd *hd) Objects(prefix string, opts *ListOptions) ObjectIterator {
+	return newChanIterator(func(yield func(*ObjectInfo) error) error {
+		if _, err := hd.getAbsDirPath(prefix); err != nil {
+			return err
+		}
+		match, err := opts.matcher(true)
+		if err != nil {
+			return err
+		}
+		return hd.walkRange(prefix, opts, func(object *ObjectInfo) error {
+			if !opts.inRange(object.name()) || !match(object) {
+				return nil
+			}
+			return yield(object)
+		})
+	})
+}
+
+func (hd *hd) ListMatch(pattern string, filters ...ListFilter) ([]ObjectInfo, error) {
+	return listMatch(hd, pattern, filters...)
+}
+
+// walkRange 範圍只包含單一 key 時只讀取 key 所在的分層目錄，例如 stat 單一物件，
+// 不需走訪所有的分層目錄
+func (hd *hd) walkRange(prefix string, opts *ListOptions, fn func(object *ObjectInfo) error) error {
+	key, ok := opts.exactKey(prefix)
+	if !ok || validateKey(key) != nil {
+		return hd.walkEntries(prefix, opts.delimiter(), fn)
+	}
+	if _, err := hd.resolve(key); err != nil {
+		// 走訪時同樣不會列出 .storage 下的檔案
+		return nil
+	}
+	object, err := hd.statKey(key)
+	if err != nil || object == nil {
+		return err
+	}
+	return fn(object)
+}
+
+// walkEntries 依 key 的順序走訪 prefix 之下的檔案，key 中 prefix 之後含有 delimiter 的部分合併為 prefix 回傳。
+// 啟用分層時同一個目錄分散在各分層目錄中，每層合併各分層目錄的內容排序後再往下走訪
+func (hd *hd) walkEntries(prefix string, delimiter string, fn func(object *ObjectInfo) error) error {
+	roots, err := hd.shardRoots()
+	if err != nil {
+		return err
+	}
+	baseDir := prefix[:strings.LastIndex(prefix, "/")+1]
+	return hd.walkDir(roots, baseDir, prefix, delimiter, map[string]bool{}, fn)
+}
+
+// walkDir 走訪 roots 中的 dir 目錄，roots 只包含存在 dir 的分層目錄，seen 用於合併相同的 prefix
+func (hd *hd) walkDir(roots []string, dir string, prefix string, delimiter string, seen map[string]bool, fn func(object *ObjectInfo) error) error {
+	type entry struct {
+		file fs.DirEntry
+		// 含有此子目錄的分層目錄
+		roots []string
+	}
+	// 目錄的 key 以 "/" 結尾，排序後與其下檔案的 key 順序一致
+	entries := map[string]*entry{}
+	for _, root := range roots {
+		files, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		isMetaParent := dir == "" && root == filepath.Clean(hd.Path)
+		for _, f := range files {
+			if isMetaParent && f.Name() == _hd_MetaDir {
+				continue
+			}
+			key := strAppend(dir, f.Name())
+			if f.IsDir() {
+				key = strAppend(key, "/")
+			}
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			e := entries[key]
+			if e == nil {
+				e = &entry{}
+				entries[key] = e
+			}
+			if f.IsDir() {
+				e.roots = append(e.roots, root)
+			} else {
+				e.file = f
+			}
+		}
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				// 目錄之下的 key 都有相同的 prefix，不需再往下走訪
+				p := key[:len(prefix)+i+len(delimiter)]
+				if seen[p] {
+					continue
+				}
+				seen[p] = true
+				if err := fn(&ObjectInfo{Prefix: p}); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		var err error
+		if e := entries[key]; e.file != nil {
+			err = hd.walkObject(key, e.file, fn)
+		} else {
+			err = hd.walkDir(e.roots, key, prefix, delimiter, seen, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hd *hd) walkObject(key string, d fs.DirEntry, fn func(object *ObjectInfo) error) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	generation, err := hd.generation(key)
+	if err != nil {
+		return err
+	}
+	return fn(&ObjectInfo{
+		Key:        key,
+		Size:       info.Size(),
+		Updated:    info.ModTime(),
+		Generation: generation,
+	})
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// hdUsage 快取目前的使用量，第一次需要時走訪目錄計算，之後隨寫入及刪除更新。
+// 只計算目前版本的檔案，不含保留的舊版本；多個 process 共用同一目錄時只是近似值
+type hdUsage struct {
+	mu     sync.Mutex
+	loaded bool
+	bytes  int64
+	files  int64
+}
+
+func (hd *hd) hasQuota() bool {
+	return hd.opts.MaxBytes > 0 || hd.opts.MaxFiles > 0 || hd.opts.MinFreeBytes > 0
+}
+
+func (hd *hd) loadUsage() (int64, int64, error) {
+	hd.usage.mu.Lock()
+	defer hd.usage.mu.Unlock()
+	if !hd.usage.loaded {
+		var bytes, files int64
+		err := hd.walkEntries("", "", func(object *ObjectInfo) error {
+			bytes += object.Size
+			files++
+			return nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		hd.usage.bytes, hd.usage.files, hd.usage.loaded = bytes, files, true
+	}
+	return hd.usage.bytes, hd.usage.files, nil
+}
+
+func (hd *hd) addUsage(bytes int64, files int64) {
+	hd.usage.mu.Lock()
+	defer hd.usage.mu.Unlock()
+	if hd.usage.loaded {
+		hd.usage.bytes += bytes
+		hd.usage.files += files
+	}
+}
+
+// reserve 在寫入前將 bytes 及 files 計入使用量，超過配額時不變更並回傳 ErrQuotaExceeded。
+// 檢查與預留在同一個 lock 內完成，同時寫入不同的 key 也不會超過配額
+func (hd *hd) reserve(bytes int64, files int64) error {
+	hd.usage.mu.Lock()
+	defer hd.usage.mu.Unlock()
+	if hd.opts.MaxFiles > 0 && files > 0 && hd.usage.files+files > hd.opts.MaxFiles {
+		return errors.Wrapf(ErrQuotaExceeded, "max files: %d", hd.opts.MaxFiles)
+	}
+	if hd.opts.MaxBytes > 0 && bytes > 0 && hd.usage.bytes+bytes > hd.opts.MaxBytes {
+		return errors.Wrapf(ErrQuotaExceeded, "max bytes: %d", hd.opts.MaxBytes)
+	}
+	hd.usage.bytes += bytes
+	hd.usage.files += files
+	return nil
+}
+
+// quotaReader 依配額及剩餘空間限制 reader 可寫入的大小，超過時讀取會回傳 ErrQuotaExceeded。
+// 讀取時預留增加的大小，寫入結束後需以寫入的結果呼叫 done，成功時修正為實際的大小，失敗時釋放預留的量
+func (hd *hd) quotaReader(absFilePath string, reader io.Reader) (io.Reader, func(err error), error) {
+	if !hd.hasQuota() {
+		return reader, func(error) {}, nil
+	}
+	r := &quotaReader{hd: hd, reader: reader, limit: math.MaxInt64}
+	var oldFiles int64
+	info, err := os.Stat(absFilePath)
+	if err == nil {
+		r.oldSize, oldFiles = info.Size(), 1
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	if hd.opts.MaxBytes > 0 || hd.opts.MaxFiles > 0 {
+		if _, _, err := hd.loadUsage(); err != nil {
+			return nil, nil, err
+		}
+		r.track = true
+		if oldFiles == 0 {
+			if err := hd.reserve(0, 1); err != nil {
+				return nil, nil, err
+			}
+			r.files = 1
+		}
+	}
+	if hd.opts.MinFreeBytes > 0 {
+		free, ok, err := freeSpace(hd.Path)
+		if err != nil {
+			r.done(err)
+			return nil, nil, err
+		}
+		if ok {
+			available := int64(free) - int64(hd.opts.MinFreeBytes)
+			if available < 0 {
+				err = errors.Wrapf(ErrQuotaExceeded, "free space below %d bytes", hd.opts.MinFreeBytes)
+				r.done(err)
+				return nil, nil, err
+			}
+			r.limit = available
+		}
+	}
+	return r, r.done, nil
+}
+
+type quotaReader struct {
+	hd     *hd
+	reader io.Reader
+	// track 為 true 時計算使用量
+	track   bool
+	oldSize int64
+	// limit 為剩餘空間可寫入的大小
+	limit int64
+	n     int64
+	// 已預留的大小及檔案數量
+	bytes int64
+	files int64
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	if r.n > r.limit {
+		return n, errors.Wrapf(ErrQuotaExceeded, "exceed %d bytes", r.limit)
+	}
+	if grow := r.n - r.oldSize - r.bytes; r.track && grow > 0 {
+		if qerr := r.hd.reserve(grow, 0); qerr != nil {
+			return n, qerr
+		}
+		r.bytes += grow
+	}
+	return n, err
+}
+
+func (r *quotaReader) done(err error) {
+	if !r.track {
+		return
+	}
+	if err != nil {
+		r.hd.addUsage(-r.bytes, -r.files)
+		return
+	}
+	r.hd.addUsage(r.n-r.oldSize-r.bytes, 0)
+}
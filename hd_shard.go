@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const _hd_ShardWidth = 2
+
+// shardDir 回傳 key 的分層目錄，例如 ShardLevels 為 2 時為 "ab/cd"，目錄或未啟用分層時回傳空字串
+func (hd *hd) shardDir(key string) string {
+	return shardDir(key, hd.opts.ShardLevels, hd.opts.ShardWidth)
+}
+
+func shardDir(key string, levels int, width int) string {
+	if levels <= 0 || key == "" || strings.HasSuffix(key, "/") {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	parts := make([]string, 0, levels)
+	for i := 0; i < levels && (i+1)*width <= len(hash); i++ {
+		parts = append(parts, hash[i*width:(i+1)*width])
+	}
+	return filepath.Join(parts...)
+}
+
+// shardRoots 回傳所有存在的最底層分層目錄，未啟用分層時只有根目錄
+func (hd *hd) shardRoots() ([]string, error) {
+	roots := []string{filepath.Clean(hd.Path)}
+	for level := 0; level < hd.opts.ShardLevels; level++ {
+		var next []string
+		for _, root := range roots {
+			files, err := os.ReadDir(root)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range files {
+				if f.IsDir() && isShardName(f.Name(), hd.opts.ShardWidth) {
+					next = append(next, filepath.Join(root, f.Name()))
+				}
+			}
+		}
+		roots = next
+	}
+	return roots, nil
+}
+
+func isShardName(name string, width int) bool {
+	if len(name) != width {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ReshardHd 將 path 下以 from 分層方式儲存的檔案搬移為 to 的分層方式，回傳搬移的檔案數。
+// 搬移期間不可有其他程式存取此目錄
+func ReshardHd(path string, from HdOptions, to HdOptions) (int, error) {
+	src := newHd(path, from)
+	dst := newHd(path, to)
+	if src.opts.ShardLevels == dst.opts.ShardLevels && src.opts.ShardWidth == dst.opts.ShardWidth {
+		return 0, nil
+	}
+	// 先收集所有 key，避免走訪時讀到已搬移到新位置的檔案
+	var keys []string
+	err := src.walkEntries("", "", func(object *ObjectInfo) error {
+		keys = append(keys, object.Key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, key := range keys {
+		if err = reshardPath(src.filePath(key), dst.filePath(key), dst, filepath.Clean(dst.Path)); err != nil {
+			return moved, err
+		}
+		for _, kind := range []string{_hd_GenDir, _hd_RefDir, _hd_VersionDir} {
+			stop := filepath.Join(dst.Path, _hd_MetaDir, kind)
+			if err = reshardPath(src.getMetaPath(kind, key), dst.getMetaPath(kind, key), dst, stop); err != nil {
+				return moved, err
+			}
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// reshardPath 將檔案或目錄由 oldPath 搬到 newPath，並移除因此變空的目錄
+func reshardPath(oldPath string, newPath string, dst *hd, stop string) error {
+	if oldPath == newPath {
+		return nil
+	}
+	if exist, err := fileExist(oldPath); err != nil || !exist {
+		return err
+	}
+	if err := dst.mkdir(newPath); err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	pruneDirs(filepath.Dir(oldPath), stop)
+	return nil
+}
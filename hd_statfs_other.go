@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package storage
+
+// freeSpace 不支援的平台不檢查剩餘空間
+func freeSpace(path string) (uint64, bool, error) {
+	return 0, false, nil
+}
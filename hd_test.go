@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestHdLock(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{})
+	unlock, err := h.lock("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan func())
+	go func() {
+		unlock, err := h.lock("a.txt")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- unlock
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(_hd_LockRefresh + 100*time.Millisecond):
+	}
+	// 持有者會持續更新時間，不會被視為異常結束
+	info, err := os.Stat(h.getMetaPath(_hd_LockDir, "a.txt"))
+	if err != nil || time.Since(info.ModTime()) > _hd_LockRefresh+100*time.Millisecond {
+		t.Fatalf("lock not refreshed: %v, %v", info, err)
+	}
+	unlock()
+	(<-acquired)()
+}
+
+func TestHdStaleLock(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{})
+	lockPath := h.getMetaPath(_hd_LockDir, "a.txt")
+	if err := h.mkdir(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockPath, []byte("dead"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * _hd_LockExpire)
+	os.Chtimes(lockPath, old, old)
+
+	unlock, err := h.lock("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只移除自己持有的 lock
+	os.WriteFile(lockPath, []byte("other"), 0644)
+	unlock()
+	if data, err := os.ReadFile(lockPath); err != nil || string(data) != "other" {
+		t.Fatalf("lock of other owner removed: %q, %v", data, err)
+	}
+}
+
+// TestHdQuotaConcurrent 同時寫入不同的 key 時仍不會超過配額
+func TestHdQuotaConcurrent(t *testing.T) {
+	const size, writers = 100, 30
+	for name, opts := range map[string]HdOptions{
+		"MaxFiles": {MaxFiles: 5},
+		"MaxBytes": {MaxBytes: 5 * size},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newHd(t.TempDir(), opts)
+			// 讓所有寫入都在開始讀取內容前通過配額檢查
+			start := make(chan struct{})
+			time.AfterFunc(100*time.Millisecond, func() { close(start) })
+			var wg sync.WaitGroup
+			var saved int32
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, err := h.SaveByReader(fmt.Sprintf("k%d", i), &blockingReader{start: start, data: make([]byte, size)})
+					switch {
+					case err == nil:
+						atomic.AddInt32(&saved, 1)
+					case !errors.Is(err, ErrQuotaExceeded):
+						t.Error(err)
+					}
+				}(i)
+			}
+			wg.Wait()
+			if saved != 5 {
+				t.Fatalf("saved %d files, want 5", saved)
+			}
+			bytes, files, err := h.loadUsage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes != 5*size || files != 5 {
+				t.Fatalf("usage = %d bytes, %d files", bytes, files)
+			}
+		})
+	}
+}
+
+// blockingReader 在 start 關閉前不回傳內容
+type blockingReader struct {
+	start <-chan struct{}
+	data  []byte
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.start
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// TestHdExactKeyList 範圍只包含單一 key 時直接讀取所在的分層目錄，結果與走訪相同
+func TestHdExactKeyList(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{ShardLevels: 2})
+	for _, key := range []string{"a.txt", "a.txt2", "dir/b.txt"} {
+		if _, err := h.Save(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exact := func(key string, recursive bool) []ObjectInfo {
+		t.Helper()
+		page, err := h.ListObjects(key, &ListOptions{Recursive: recursive, StartOffset: key, EndOffset: key + "\x00"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page.Objects
+	}
+	objects := exact("a.txt", true)
+	if len(objects) != 1 || objects[0].Key != "a.txt" || objects[0].Size != 5 || objects[0].Generation == 0 {
+		t.Fatalf("a.txt = %+v", objects)
+	}
+	if objects := exact("dir/b.txt", true); len(objects) != 1 {
+		t.Fatalf("dir/b.txt = %+v", objects)
+	}
+	for _, key := range []string{"missing.txt", "a.txt/c"} {
+		if objects := exact(key, true); len(objects) != 0 {
+			t.Fatalf("%s = %+v", key, objects)
+		}
+	}
+	// 以 "/" 分隔時 prefix 之後的目錄合併為 prefix
+	page, err := h.ListObjects("", &ListOptions{StartOffset: "dir/b.txt", EndOffset: "dir/b.txt\x00"})
+	if err != nil || len(page.Objects) != 0 {
+		t.Fatalf("non-recursive = %+v, %v", page, err)
+	}
+	page, err = h.ListObjects("", &ListOptions{Recursive: true, StartOffset: ".storage/tmp", EndOffset: ".storage/tmp\x00"})
+	if err != nil || len(page.Objects) != 0 {
+		t.Fatalf(".storage = %+v, %v", page, err)
+	}
+}
+
+// TestHdVersions 只保留最近 KeepVersions 個舊版本，還原時產生新的 generation
+func TestHdVersions(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{KeepVersions: 2})
+	for i := 1; i <= 4; i++ {
+		if _, err := h.Save("a.txt", []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := h.ListVersions("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].IsLatest || versions[1].IsLatest {
+		t.Fatalf("versions = %+v", versions)
+	}
+	for i, want := range []string{"v4", "v3", "v2"} {
+		data, err := h.GetVersion("a.txt", versions[i].Generation)
+		if err != nil || string(data) != want {
+			t.Fatalf("version %d = %q, %v, want %q", versions[i].Generation, data, err, want)
+		}
+	}
+
+	// 還原最舊的版本時該版本會因保存目前版本而被刪除
+	oldest := versions[2].Generation
+	if _, err = h.RestoreVersion("a.txt", oldest); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := h.Get("a.txt"); err != nil || string(data) != "v2" {
+		t.Fatalf("restored = %q, %v", data, err)
+	}
+	restored, err := h.ListVersions("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 3 || restored[0].Generation <= versions[0].Generation || restored[1].Generation != versions[0].Generation {
+		t.Fatalf("versions after restore = %+v", restored)
+	}
+	if _, err = h.GetVersion("a.txt", oldest); !os.IsNotExist(err) {
+		t.Fatalf("pruned version = %v", err)
+	}
+	if err = h.DeleteVersion("a.txt", oldest); err == nil {
+		t.Fatal("delete of pruned version succeeded")
+	}
+}
+
+// TestHdDeleteCurrentVersion 刪除目前版本時不保留歷史，並釋放配額
+func TestHdDeleteCurrentVersion(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{KeepVersions: 2, MaxFiles: 1})
+	for _, data := range []string{"v1", "v2"} {
+		if _, err := h.Save("a.txt", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := h.ListVersions("a.txt")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("versions = %+v, %v", versions, err)
+	}
+	if err = h.DeleteVersion("a.txt", versions[0].Generation); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.Get("a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("current version = %v", err)
+	}
+	remain, err := h.ListVersions("a.txt")
+	if err != nil || len(remain) != 1 || remain[0].Generation != versions[1].Generation || remain[0].IsLatest {
+		t.Fatalf("versions after delete = %+v, %v", remain, err)
+	}
+	// 舊版本不計入配額
+	if bytes, files, err := h.loadUsage(); err != nil || bytes != 0 || files != 0 {
+		t.Fatalf("usage = %d bytes, %d files, %v", bytes, files, err)
+	}
+	if _, err = h.Save("b.txt", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.RestoreVersion("a.txt", remain[0].Generation); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("restore over quota = %v", err)
+	}
+}
+
+// TestHdDedupVersions 舊版本保留 blob 的參照，刪除版本後釋放不再使用的 blob
+func TestHdDedupVersions(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{KeepVersions: 1, Dedup: true})
+	blobs := func() int { return countBlobs(t, h) }
+	for _, data := range []string{"v1", "v2", "v3"} {
+		if _, err := h.Save("a.txt", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// v1 隨版本刪除而釋放，v2 仍由舊版本參照
+	if n := blobs(); n != 2 {
+		t.Fatalf("blobs = %d, want 2", n)
+	}
+	versions, err := h.ListVersions("a.txt")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("versions = %+v, %v", versions, err)
+	}
+	if _, err = h.RestoreVersion("a.txt", versions[1].Generation); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := h.Get("a.txt"); err != nil || string(data) != "v2" {
+		t.Fatalf("restored = %q, %v", data, err)
+	}
+	if n := blobs(); n != 2 {
+		t.Fatalf("blobs after restore = %d, want 2", n)
+	}
+	versions, err = h.ListVersions("a.txt")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("versions = %+v, %v", versions, err)
+	}
+	if err = h.DeleteVersion("a.txt", versions[1].Generation); err != nil {
+		t.Fatal(err)
+	}
+	// Delete 也會保存目前版本，刪除所有版本後 blob 才會釋放
+	if err = h.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobs(); n != 1 {
+		t.Fatalf("blobs after delete = %d, want 1", n)
+	}
+	versions, err = h.ListVersions("a.txt")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("versions after delete = %+v, %v", versions, err)
+	}
+	if err = h.DeleteVersion("a.txt", versions[0].Generation); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobs(); n != 0 {
+		t.Fatalf("blobs after delete version = %d, want 0", n)
+	}
+	if removed, err := h.GC(); err != nil || removed != 0 {
+		t.Fatalf("GC = %d, %v", removed, err)
+	}
+}
+
+// TestHdModes 建立的檔案及目錄套用 FileMode、DirMode 並移除 Umask 的權限
+func TestHdModes(t *testing.T) {
+	dir := t.TempDir()
+	h := newHd(dir, HdOptions{FileMode: 0666, DirMode: 0777, Umask: 0027})
+	if _, err := h.Save("a/b/c.txt", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]os.FileMode{
+		"a":         0750,
+		"a/b":       0750,
+		"a/b/c.txt": 0640,
+	} {
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s mode = %v, want %v", path, info.Mode().Perm(), want)
+		}
+	}
+
+	// 未設定時為 0644 及 0755
+	h = newHd(t.TempDir(), HdOptions{})
+	if h.fileMode() != 0644 || h.dirMode() != 0755 {
+		t.Fatalf("default modes = %v, %v", h.fileMode(), h.dirMode())
+	}
+}
+
+// TestHdDedup 相同內容只保留一個 blob，所有參照刪除後才刪除 blob
+func TestHdDedup(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{Dedup: true})
+	for _, key := range []string{"a.txt", "dir/b.txt"} {
+		if _, err := h.Save(key, []byte("same")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.Save("c.txt", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlobs(t, h); n != 2 {
+		t.Fatalf("blobs = %d, want 2", n)
+	}
+	a, err := os.Stat(h.filePath("a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(h.filePath("dir/b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Fatal("keys with the same content are not linked to the same blob")
+	}
+
+	// 覆寫後原本的內容仍由 dir/b.txt 參照
+	if _, err = h.Save("a.txt", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlobs(t, h); n != 2 {
+		t.Fatalf("blobs after overwrite = %d, want 2", n)
+	}
+	if err = h.Delete("dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlobs(t, h); n != 1 {
+		t.Fatalf("blobs after delete = %d, want 1", n)
+	}
+	if data, err := h.Get("a.txt"); err != nil || string(data) != "other" {
+		t.Fatalf("a.txt = %q, %v", data, err)
+	}
+}
+
+// TestHdGC 刪除沒有參照的 blob 及過期的暫存檔，寫入中的暫存檔不受影響
+func TestHdGC(t *testing.T) {
+	h := newHd(t.TempDir(), HdOptions{Dedup: true})
+	if _, err := h.Save("a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// 模擬寫入 blob 後連結 key 前中斷
+	orphan := h.getBlobPath(strings.Repeat("0", 64))
+	if err := h.mkdir(orphan); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphan, []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpDir := filepath.Join(h.Path, _hd_MetaDir, _hd_TmpDir)
+	stale, fresh := filepath.Join(tmpDir, "stale"), filepath.Join(tmpDir, "fresh")
+	for _, path := range []string{stale, fresh} {
+		if err := os.WriteFile(path, []byte("tmp"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired := time.Now().Add(-2 * _hd_TmpExpire)
+	if err := os.Chtimes(stale, expired, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := h.GC()
+	if err != nil || removed != 1 {
+		t.Fatalf("GC = %d, %v", removed, err)
+	}
+	if _, err = os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("orphan blob = %v", err)
+	}
+	if _, err = os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale temp file = %v", err)
+	}
+	if _, err = os.Stat(fresh); err != nil {
+		t.Fatalf("fresh temp file = %v", err)
+	}
+	if data, err := h.Get("a.txt"); err != nil || string(data) != "a" {
+		t.Fatalf("a.txt = %q, %v", data, err)
+	}
+	if removed, err = h.GC(); err != nil || removed != 0 {
+		t.Fatalf("second GC = %d, %v", removed, err)
+	}
+}
+
+// countBlobs 回傳 dedup 目錄中 blob 的數量
+func countBlobs(t *testing.T, h *hd) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(filepath.Join(h.Path, _hd_MetaDir, _hd_BlobDir), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestHdMinFreeBytes 寫入後剩餘空間低於 MinFreeBytes 時拒絕寫入，且不留下檔案及使用量
+func TestHdMinFreeBytes(t *testing.T) {
+	dir := t.TempDir()
+	free, ok, err := freeSpace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || free < 1<<20 {
+		t.Skip("free space is not available")
+	}
+
+	h := newHd(dir, HdOptions{MinFreeBytes: free + 1<<30})
+	if _, err = h.Save("full.txt", []byte("a")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("save below free space = %v", err)
+	}
+
+	// 只剩 64KB 可寫入，超過時在寫入途中失敗
+	h = newHd(dir, HdOptions{MinFreeBytes: free - 64<<10, MaxBytes: 1 << 40})
+	if _, err = h.Save("small.txt", make([]byte, 1<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.Save("large.txt", make([]byte, 16<<20)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("save over free space = %v", err)
+	}
+	if _, err = os.Stat(h.filePath("large.txt")); !os.IsNotExist(err) {
+		t.Fatalf("large.txt = %v", err)
+	}
+	if bytes, files, err := h.loadUsage(); err != nil || bytes != 1<<10 || files != 1 {
+		t.Fatalf("usage = %d bytes, %d files, %v", bytes, files, err)
+	}
+	tmp, err := os.ReadDir(filepath.Join(h.Path, _hd_MetaDir, _hd_TmpDir))
+	if err != nil || len(tmp) != 0 {
+		t.Fatalf("temp files = %v, %v", tmp, err)
+	}
+}
+
+// TestHdFullPath key 不合法時 FullPath 回傳空字串，ResolvePath 回傳 ErrInvalidKey
+func TestHdFullPath(t *testing.T) {
+	dir := t.TempDir()
+	h := newHd(dir, HdOptions{})
+	if path := h.FullPath("a/b.txt"); path != filepath.Join(dir, "a/b.txt") {
+		t.Fatalf("FullPath = %q", path)
+	}
+	if path, err := h.ResolvePath("a/b.txt"); err != nil || path != h.FullPath("a/b.txt") {
+		t.Fatalf("ResolvePath = %q, %v", path, err)
+	}
+	if path := h.FullPath("../escape.txt"); path != "" {
+		t.Fatalf("FullPath of escaping key = %q", path)
+	}
+	if _, err := h.ResolvePath("../escape.txt"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("ResolvePath of escaping key = %v", err)
+	}
+}
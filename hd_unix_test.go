@@ -0,0 +1,33 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestHdOwner 建立的檔案及目錄變更為 Uid 及 Gid，非 root 時只能驗證設為自己
+func TestHdOwner(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = 1234, 5678
+	}
+	dir := t.TempDir()
+	h := newHd(dir, HdOptions{Uid: &uid, Gid: &gid})
+	if _, err := h.Save("a/b.txt", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a", "a/b.txt"} {
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if int(stat.Uid) != uid || int(stat.Gid) != gid {
+			t.Errorf("%s owner = %d:%d, want %d:%d", path, stat.Uid, stat.Gid, uid, gid)
+		}
+	}
+}
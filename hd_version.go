@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// dedup 模式時版本旁記錄連結的 blob，刪除版本時才能釋放 blob
+const _hd_VersionRefSuffix = ".ref"
+
+func (hd *hd) getVersionPath(fp string, generation int64) string {
+	return filepath.Join(hd.getMetaPath(_hd_VersionDir, fp), strconv.FormatInt(generation, 10))
+}
+
+// archive 將目前的檔案移到版本目錄，並只保留最近 KeepVersions 個版本
+func (hd *hd) archive(fp string) error {
+	if hd.opts.KeepVersions <= 0 {
+		return nil
+	}
+	generation, err := hd.generation(fp)
+	if err != nil || generation == 0 {
+		return err
+	}
+	versionPath := hd.getVersionPath(fp, generation)
+	if err = hd.mkdir(versionPath); err != nil {
+		return err
+	}
+	// 以 hard link 保留舊版本，目前的檔案在寫入新版本前仍可讀取
+	if err = os.Link(hd.filePath(fp), versionPath); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if hd.opts.Dedup {
+		sum, err := os.ReadFile(hd.getMetaPath(_hd_RefDir, fp))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(sum) > 0 {
+			if err = hd.writeAtomic(versionPath+_hd_VersionRefSuffix, bytes.NewReader(sum), false); err != nil {
+				return err
+			}
+		}
+	}
+	versions, err := hd.archivedVersions(fp)
+	if err != nil {
+		return err
+	}
+	for i := hd.opts.KeepVersions; i < len(versions); i++ {
+		err = hd.removeVersion(fp, versions[i].Generation)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivedVersions 依 generation 由新到舊排序
+func (hd *hd) archivedVersions(fp string) ([]*ObjectVersion, error) {
+	files, err := os.ReadDir(hd.getMetaPath(_hd_VersionDir, fp))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result []*ObjectVersion
+	for _, f := range files {
+		generation, err := strconv.ParseInt(f.Name(), 10, 64)
+		if err != nil || f.IsDir() {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &ObjectVersion{
+			Key:        fp,
+			Generation: generation,
+			Size:       info.Size(),
+			Updated:    info.ModTime(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Generation > result[j].Generation
+	})
+	return result, nil
+}
+
+func (hd *hd) ListVersions(fp string) ([]*ObjectVersion, error) {
+	absFilePath, err := hd.getAbsFilePath(fp)
+	if err != nil {
+		return nil, err
+	}
+	var result []*ObjectVersion
+	generation, err := hd.generation(fp)
+	if err != nil {
+		return nil, err
+	}
+	if generation != 0 {
+		info, err := os.Stat(absFilePath)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &ObjectVersion{
+			Key:        fp,
+			Generation: generation,
+			Size:       info.Size(),
+			Updated:    info.ModTime(),
+			IsLatest:   true,
+		})
+	}
+	versions, err := hd.archivedVersions(fp)
+	if err != nil {
+		return nil, err
+	}
+	return append(result, versions...), nil
+}
+
+func (hd *hd) GetVersion(fp string, generation int64) ([]byte, error) {
+	if _, err := hd.getAbsFilePath(fp); err != nil {
+		return nil, err
+	}
+	current, err := hd.generation(fp)
+	if err != nil {
+		return nil, err
+	}
+	if current != 0 && current == generation {
+		return hd.Get(fp)
+	}
+	return os.ReadFile(hd.getVersionPath(fp, generation))
+}
+
+func (hd *hd) RestoreVersion(fp string, generation int64) (string, error) {
+	absFilePath, err := hd.getAbsFilePath(fp)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := hd.lock(fp)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	current, err := hd.generation(fp)
+	if err != nil {
+		return "", err
+	}
+	if current != 0 && current == generation {
+		return absFilePath, nil
+	}
+	// 先開啟版本檔，save 保存目前版本時即使刪除了此版本仍可讀取
+	f, err := os.Open(hd.getVersionPath(fp, generation))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hd.save(fp, f)
+}
+
+func (hd *hd) DeleteVersion(fp string, generation int64) error {
+	if _, err := hd.getAbsFilePath(fp); err != nil {
+		return err
+	}
+	unlock, err := hd.lock(fp)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := hd.generation(fp)
+	if err != nil {
+		return err
+	}
+	// 指定 generation 刪除目前版本時不保留歷史，與 gcs 相同
+	if current != 0 && current == generation {
+		return hd.removeCurrent(fp)
+	}
+	err = hd.removeVersion(fp, generation)
+	if os.IsNotExist(err) {
+		return errors.Errorf("version not exist: %s#%d", fp, generation)
+	}
+	return err
+}
+
+func (hd *hd) removeVersion(fp string, generation int64) error {
+	versionPath := hd.getVersionPath(fp, generation)
+	if err := os.Remove(versionPath); err != nil {
+		return err
+	}
+	if hd.opts.Dedup {
+		sum, err := os.ReadFile(versionPath + _hd_VersionRefSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(sum) > 0 {
+			if err = os.Remove(versionPath + _hd_VersionRefSuffix); err != nil {
+				return err
+			}
+			if _, err = hd.removeUnreferencedBlob(hd.getBlobPath(string(sum))); err != nil {
+				return err
+			}
+		}
+	}
+	pruneDirs(filepath.Dir(versionPath), filepath.Join(hd.Path, _hd_MetaDir, _hd_VersionDir))
+	return nil
+}
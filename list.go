@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ListOptions 未指定 Delimiter 時以 "/" 分隔目錄，Recursive 為 true 時列出所有子目錄的物件。
+// PageSize 為 0 時不分頁。MatchGlob 及 Filter 只篩選物件，不影響 prefixes。
+type ListOptions struct {
+	Delimiter   string
+	Recursive   bool
+	PageSize    int
+	PageToken   string
+	StartOffset string
+	EndOffset   string
+	MatchGlob   string
+	Filter      ListFilter
+}
+
+// matcher 回傳篩選物件的函式，withGlob 為 false 時只套用 Filter
+func (opts *ListOptions) matcher(withGlob bool) (func(o *ObjectInfo) bool, error) {
+	if opts == nil {
+		return func(o *ObjectInfo) bool { return true }, nil
+	}
+	filter := opts.Filter
+	if !withGlob || opts.MatchGlob == "" {
+		return func(o *ObjectInfo) bool {
+			return o.Prefix != "" || filter.match(o)
+		}, nil
+	}
+	glob, err := compileGlob(opts.MatchGlob)
+	if err != nil {
+		return nil, err
+	}
+	return func(o *ObjectInfo) bool {
+		return o.Prefix != "" || (glob.MatchString(o.Key) && filter.match(o))
+	}, nil
+}
+
+func (opts *ListOptions) delimiter() string {
+	if opts == nil {
+		return "/"
+	}
+	if opts.Recursive {
+		return ""
+	}
+	if opts.Delimiter == "" {
+		return "/"
+	}
+	return opts.Delimiter
+}
+
+// inRange 檢查 name 是否在 StartOffset 與 EndOffset 之間
+func (opts *ListOptions) inRange(name string) bool {
+	if opts == nil {
+		return true
+	}
+	if opts.StartOffset != "" && name < opts.StartOffset {
+		return false
+	}
+	if opts.EndOffset != "" && name >= opts.EndOffset {
+		return false
+	}
+	return true
+}
+
+// exactKey 範圍只可能包含一個 key 時回傳該 key，例如 StartOffset 為 key 且 EndOffset 為 key+"\x00"
+func (opts *ListOptions) exactKey(prefix string) (string, bool) {
+	if opts == nil || opts.StartOffset == "" || opts.EndOffset != opts.StartOffset+"\x00" {
+		return "", false
+	}
+	key := opts.StartOffset
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	// key 在 prefix 之後含有 delimiter 時會合併為 prefix，不是物件
+	if d := opts.delimiter(); d != "" && strings.Contains(key[len(prefix):], d) {
+		return "", false
+	}
+	return key, true
+}
+
+type ObjectInfo struct {
+	Key        string
+	Size       int64
+	Updated    time.Time
+	Generation int64
+
+	// 只在 ObjectIterator 中使用，不為空時代表以 delimiter 合併的目錄，其餘欄位皆為空值
+	Prefix string
+}
+
+func (o *ObjectInfo) name() string {
+	if o.Prefix != "" {
+		return o.Prefix
+	}
+	return o.Key
+}
+
+type ListPage struct {
+	Objects   []ObjectInfo
+	Prefixes  []string
+	NextToken string
+}
+
+type ObjectLister interface {
+	ObjectMatcher
+	ListObjects(prefix string, opts *ListOptions) (*ListPage, error)
+	// Objects 逐筆依 key 的順序列出物件，忽略 PageSize 及 PageToken。
+	// 以 delimiter 列出時 prefix 同樣依序回傳，但 prefix 與物件之間的相對順序依實作而定
+	Objects(prefix string, opts *ListOptions) ObjectIterator
+}
+
+var IteratorDone = errors.New("no more items in iterator")
+
+type ObjectIterator interface {
+	// Next 沒有更多項目時回傳 IteratorDone
+	Next() (*ObjectInfo, error)
+	// Stop 提前結束列出並釋放資源
+	Stop()
+}
+
+// AllObjects 將 ObjectIterator 轉為 range-over-func 可用的形式，迴圈結束時會呼叫 Stop
+func AllObjects(it ObjectIterator) func(yield func(*ObjectInfo, error) bool) {
+	return func(yield func(*ObjectInfo, error) bool) {
+		defer it.Stop()
+		for {
+			object, err := it.Next()
+			if err == IteratorDone {
+				return
+			}
+			if !yield(object, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+var errIteratorStopped = errors.New("iterator stopped")
+
+// chanIterator 在 goroutine 中執行 produce，並透過 channel 逐筆交給 Next
+type chanIterator struct {
+	items chan *ObjectInfo
+	done  chan struct{}
+	err   error
+	once  sync.Once
+}
+
+func newChanIterator(produce func(yield func(*ObjectInfo) error) error) ObjectIterator {
+	it := &chanIterator{
+		items: make(chan *ObjectInfo),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(it.items)
+		err := produce(func(object *ObjectInfo) error {
+			select {
+			case it.items <- object:
+				return nil
+			case <-it.done:
+				return errIteratorStopped
+			}
+		})
+		if err != nil && err != errIteratorStopped {
+			it.err = err
+		}
+	}()
+	return it
+}
+
+func (it *chanIterator) Next() (*ObjectInfo, error) {
+	select {
+	case <-it.done:
+		return nil, IteratorDone
+	default:
+	}
+	object, ok := <-it.items
+	if ok {
+		return object, nil
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return nil, IteratorDone
+}
+
+func (it *chanIterator) Stop() {
+	it.once.Do(func() {
+		close(it.done)
+	})
+}
+
+// mergePage 將 page 中的物件及 prefix 依名稱排序合併，供 ObjectIterator 逐筆回傳
+func mergePage(page *ListPage) []*ObjectInfo {
+	entries := make([]*ObjectInfo, 0, len(page.Objects)+len(page.Prefixes))
+	for i := range page.Objects {
+		entries = append(entries, &page.Objects[i])
+	}
+	for _, p := range page.Prefixes {
+		entries = append(entries, &ObjectInfo{Prefix: p})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name() < entries[j].name()
+	})
+	return entries
+}
+
+// paginate 依 key 排序後套用 offset 及分頁，page token 為上一頁最後一筆的 key
+func paginate(objects []ObjectInfo, prefixes []string, opts *ListOptions) *ListPage {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	type entry struct {
+		name   string
+		object *ObjectInfo
+	}
+	entries := make([]entry, 0, len(objects)+len(prefixes))
+	for i := range objects {
+		entries = append(entries, entry{name: objects[i].name(), object: &objects[i]})
+	}
+	for _, p := range prefixes {
+		entries = append(entries, entry{name: p})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	page := &ListPage{}
+	count := 0
+	last := ""
+	for _, e := range entries {
+		if !opts.inRange(e.name) {
+			continue
+		}
+		if opts.PageToken != "" && e.name <= opts.PageToken {
+			continue
+		}
+		if opts.PageSize > 0 && count == opts.PageSize {
+			page.NextToken = last
+			break
+		}
+		if e.object != nil {
+			page.Objects = append(page.Objects, *e.object)
+		} else {
+			page.Prefixes = append(page.Prefixes, e.name)
+		}
+		last = e.name
+		count++
+	}
+	return page
+}
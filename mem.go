@@ -0,0 +1,638 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	googstorage "cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// MemStorage 將檔案存放於記憶體，行為與 gcs 相同，供測試使用。
+// 所有方法可同時呼叫，並可注入延遲及錯誤模擬後端異常
+type MemStorage interface {
+	GcpStorage
+	// SetLatency 讓 method 每次呼叫前等待 d，method 為空字串時套用於所有方法
+	SetLatency(method string, d time.Duration)
+	// FailOn 讓 method 第 n 次呼叫回傳 err，n 為 0 時每次都回傳，method 為空字串時以所有方法的呼叫次數計算
+	FailOn(method string, n int, err error)
+	// Calls 回傳 method 被呼叫的次數，method 為空字串時回傳所有方法的呼叫次數
+	Calls(method string) int
+	// ResetFaults 清除所有注入的延遲、錯誤及呼叫次數
+	ResetFaults()
+}
+
+type MemOptions struct {
+	// 覆寫或刪除物件時保留最近幾個舊版本，0 表示不保留
+	KeepVersions int `yaml:"keepVersions"`
+}
+
+// NewMemStorage 保留所有的舊版本
+func NewMemStorage() MemStorage {
+	return newMem(-1)
+}
+
+// NewMemStorageWithOptions 依 opts.KeepVersions 限制舊版本的數量，避免經常覆寫的 key 讓記憶體無限增加
+func NewMemStorageWithOptions(opts MemOptions) MemStorage {
+	return newMem(max(opts.KeepVersions, 0))
+}
+
+// newMem keepVersions 小於 0 時不限制舊版本的數量
+func newMem(keepVersions int) *mem {
+	return &mem{
+		keepVersions: keepVersions,
+		objects:      map[string]*memObject{},
+		versions:     map[string][]*memObject{},
+		latency:      map[string]time.Duration{},
+		calls:        map[string]int{},
+	}
+}
+
+type memObject struct {
+	data       []byte
+	generation int64
+	created    time.Time
+	updated    time.Time
+	deleted    time.Time
+}
+
+type memFault struct {
+	method string
+	n      int
+	err    error
+}
+
+type mem struct {
+	keepVersions int
+
+	mu             sync.RWMutex
+	objects        map[string]*memObject
+	versions       map[string][]*memObject
+	lastGeneration int64
+
+	faultMu sync.Mutex
+	latency map[string]time.Duration
+	faults  []memFault
+	calls   map[string]int
+}
+
+func (m *mem) SetLatency(method string, d time.Duration) {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	m.latency[method] = d
+}
+
+func (m *mem) FailOn(method string, n int, err error) {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	m.faults = append(m.faults, memFault{method: method, n: n, err: err})
+}
+
+func (m *mem) Calls(method string) int {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	return m.calls[method]
+}
+
+func (m *mem) ResetFaults() {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	m.latency = map[string]time.Duration{}
+	m.faults = nil
+	m.calls = map[string]int{}
+}
+
+// inject 記錄呼叫次數並套用注入的延遲及錯誤，每個公開方法開始時呼叫
+func (m *mem) inject(method string) error {
+	m.faultMu.Lock()
+	m.calls[method]++
+	m.calls[""]++
+	delay := m.latency[""] + m.latency[method]
+	var err error
+	for _, f := range m.faults {
+		if f.method != "" && f.method != method {
+			continue
+		}
+		if f.n == 0 || f.n == m.calls[f.method] {
+			err = f.err
+			break
+		}
+	}
+	m.faultMu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return err
+}
+
+// nextGeneration 與 hd 相同以微秒時間為 generation，並確保遞增，呼叫前需持有 m.mu
+func (m *mem) nextGeneration() int64 {
+	generation := time.Now().UnixMicro()
+	if generation <= m.lastGeneration {
+		generation = m.lastGeneration + 1
+	}
+	m.lastGeneration = generation
+	return generation
+}
+
+func notExist(op string, key string) error {
+	return fmt.Errorf("%s: %q: %w", op, key, googstorage.ErrObjectNotExist)
+}
+
+func (m *mem) Save(filePath string, file []byte) (string, error) {
+	if err := m.inject("Save"); err != nil {
+		return "", err
+	}
+	return m.put(filePath, file, nil)
+}
+
+func (m *mem) SaveByReader(fp string, reader io.Reader) (string, error) {
+	if err := m.inject("SaveByReader"); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("write file error: %w", err)
+	}
+	return m.put(fp, data, nil)
+}
+
+func (m *mem) Write(key string, writeData func(w io.Writer) error) (string, error) {
+	if err := m.inject("Write"); err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := writeData(buf); err != nil {
+		return "", fmt.Errorf("write file error: %w", err)
+	}
+	return m.put(key, buf.Bytes(), nil)
+}
+
+func (m *mem) SaveIfNotExists(key string, file []byte) (string, error) {
+	if err := m.inject("SaveIfNotExists"); err != nil {
+		return "", err
+	}
+	var generation int64
+	return m.put(key, file, &generation)
+}
+
+func (m *mem) SaveIfMatch(key string, file []byte, generation int64) (string, error) {
+	if err := m.inject("SaveIfMatch"); err != nil {
+		return "", err
+	}
+	return m.put(key, file, &generation)
+}
+
+// put 寫入新版本，ifGeneration 不為 nil 時目前的 generation 必須相同，0 表示檔案不可存在
+func (m *mem) put(key string, file []byte, ifGeneration *int64) (string, error) {
+	if key == "" {
+		return "", errors.Wrap(ErrInvalidKey, "key can not be empty")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.objects[key]
+	if ifGeneration != nil && m.generation(current) != *ifGeneration {
+		return "", errors.Wrapf(ErrPreconditionFailed, "createFile: file %q", key)
+	}
+	now := time.Now()
+	object := &memObject{
+		data:       append([]byte{}, file...),
+		generation: m.nextGeneration(),
+		created:    now,
+		updated:    now,
+	}
+	m.archive(key, current, now)
+	m.objects[key] = object
+	return key, nil
+}
+
+func (m *mem) generation(object *memObject) int64 {
+	if object == nil {
+		return 0
+	}
+	return object.generation
+}
+
+// archive 將被覆寫或刪除的版本保留為非目前版本，與開啟版本控制的 bucket 相同，並只保留最近 keepVersions 個版本
+func (m *mem) archive(key string, object *memObject, now time.Time) {
+	if object == nil || m.keepVersions == 0 {
+		return
+	}
+	object.deleted = now
+	versions := append(m.versions[key], object)
+	if m.keepVersions > 0 && len(versions) > m.keepVersions {
+		// 複製到新的 slice，讓被移除的版本可以被回收
+		versions = append([]*memObject(nil), versions[len(versions)-m.keepVersions:]...)
+	}
+	m.versions[key] = versions
+}
+
+func (m *mem) Delete(key string) error {
+	if err := m.inject("Delete"); err != nil {
+		return err
+	}
+	return m.delete(key, nil)
+}
+
+func (m *mem) DeleteIfMatch(key string, generation int64) error {
+	if err := m.inject("DeleteIfMatch"); err != nil {
+		return err
+	}
+	return m.delete(key, &generation)
+}
+
+func (m *mem) delete(key string, ifGeneration *int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.objects[key]
+	if ifGeneration != nil && (current == nil || current.generation != *ifGeneration) {
+		return errors.Wrapf(ErrPreconditionFailed, "delete: file %q", key)
+	}
+	if current == nil {
+		return notExist("delete", key)
+	}
+	m.archive(key, current, time.Now())
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *mem) DeletePrefix(prefix string) error {
+	if err := m.inject("DeletePrefix"); err != nil {
+		return err
+	}
+	if prefix == "" {
+		return errors.Wrap(ErrInvalidKey, "prefix can not be empty")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, object := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			m.archive(key, object, now)
+			delete(m.objects, key)
+		}
+	}
+	return nil
+}
+
+func (m *mem) Generation(key string) (int64, error) {
+	if err := m.inject("Generation"); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.generation(m.objects[key]), nil
+}
+
+func (m *mem) Get(key string) ([]byte, error) {
+	if err := m.inject("Get"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, notExist("Get", key)
+	}
+	return append([]byte{}, object.data...), nil
+}
+
+func (m *mem) OpenFile(key string) (io.Reader, error) {
+	if err := m.inject("OpenFile"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, notExist("OpenFile", key)
+	}
+	return bytes.NewReader(append([]byte{}, object.data...)), nil
+}
+
+func (m *mem) NewRangeReader(key string, offset, length int64) (io.ReadCloser, error) {
+	if err := m.inject("NewRangeReader"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, notExist("NewRangeReader", key)
+	}
+	return io.NopCloser(bytes.NewReader(append([]byte{}, byteRange(object.data, offset, length)...))), nil
+}
+
+func (m *mem) GetAttr(key string) (*googstorage.ObjectAttrs, error) {
+	if err := m.inject("GetAttr"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, googstorage.ErrObjectNotExist
+	}
+	return &googstorage.ObjectAttrs{
+		Name:       key,
+		Size:       int64(len(object.data)),
+		Generation: object.generation,
+		Created:    object.created,
+		Updated:    object.updated,
+		MediaLink:  memURL(key),
+	}, nil
+}
+
+func (m *mem) FileExist(fp string) (bool, error) {
+	if err := m.inject("FileExist"); err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.objects[fp]
+	return ok, nil
+}
+
+func (m *mem) BatchDelete(keys []string) ([]BatchResult, error) {
+	if err := m.inject("BatchDelete"); err != nil {
+		return nil, err
+	}
+	return batchDelete(m, keys), nil
+}
+
+func (m *mem) BatchExist(keys []string) ([]BatchResult, error) {
+	if err := m.inject("BatchExist"); err != nil {
+		return nil, err
+	}
+	return batchExist(m, keys), nil
+}
+
+func (m *mem) BatchStat(keys []string) ([]BatchResult, error) {
+	if err := m.inject("BatchStat"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return batchStat(keys, func(key string) (*ObjectInfo, error) {
+		object := m.objects[key]
+		if object == nil {
+			return nil, nil
+		}
+		return &ObjectInfo{
+			Key:        key,
+			Size:       int64(len(object.data)),
+			Updated:    object.updated,
+			Generation: object.generation,
+		}, nil
+	}), nil
+}
+
+func memURL(key string) string {
+	return (&url.URL{Scheme: "mem", Path: "/" + key}).String()
+}
+
+// GetDownloadUrl 回傳 mem:// 開頭的連結及固定的 access token
+func (m *mem) GetDownloadUrl(key string) (*DownloadUrl, error) {
+	if err := m.inject("GetDownloadUrl"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	_, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, googstorage.ErrObjectNotExist
+	}
+	return &DownloadUrl{
+		Url:         memURL(key),
+		AccessToken: memToken(),
+	}, nil
+}
+
+func (m *mem) SignedURL(key string, contentType string, expDuration time.Duration) (string, error) {
+	if err := m.inject("SignedURL"); err != nil {
+		return "", err
+	}
+	u, _ := url.Parse(memURL(key))
+	query := url.Values{}
+	query.Set("Expires", fmt.Sprint(time.Now().Add(expDuration).Unix()))
+	if contentType != "" {
+		query.Set("Content-Type", contentType)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (m *mem) GetAccessToken() (*oauth2.Token, error) {
+	if err := m.inject("GetAccessToken"); err != nil {
+		return nil, err
+	}
+	return memToken(), nil
+}
+
+func memToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken: "mem-access-token",
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+	}
+}
+
+// List 與 gcs 相同列出 dir 之下所有的檔案，不包含目錄
+func (m *mem) List(dir string) ([]string, error) {
+	if err := m.inject("List"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []string{}
+	for key := range m.objects {
+		if strings.HasPrefix(key, dir) {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (m *mem) ListObjects(prefix string, opts *ListOptions) (*ListPage, error) {
+	if err := m.inject("ListObjects"); err != nil {
+		return nil, err
+	}
+	objects, prefixes, err := m.listEntries(prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(objects, prefixes, opts), nil
+}
+
+func (m *mem) Objects(prefix string, opts *ListOptions) ObjectIterator {
+	if err := m.inject("Objects"); err != nil {
+		return newChanIterator(func(yield func(*ObjectInfo) error) error {
+			return err
+		})
+	}
+	objects, prefixes, err := m.listEntries(prefix, opts)
+	all := &ListOptions{}
+	if opts != nil {
+		*all = *opts
+		all.PageSize, all.PageToken = 0, ""
+	}
+	page := paginate(objects, prefixes, all)
+	return newChanIterator(func(yield func(*ObjectInfo) error) error {
+		if err != nil {
+			return err
+		}
+		for _, object := range mergePage(page) {
+			if err := yield(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *mem) ListMatch(pattern string, filters ...ListFilter) ([]ObjectInfo, error) {
+	return listMatch(m, pattern, filters...)
+}
+
+// listEntries 取得 prefix 之下符合條件的物件，key 中 prefix 之後含有 delimiter 的部分合併為 prefix
+func (m *mem) listEntries(prefix string, opts *ListOptions) ([]ObjectInfo, []string, error) {
+	match, err := opts.matcher(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	delimiter := opts.delimiter()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var objects []ObjectInfo
+	var prefixes []string
+	seen := map[string]bool{}
+	for key, object := range m.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					prefixes = append(prefixes, p)
+				}
+				continue
+			}
+		}
+		info := ObjectInfo{
+			Key:        key,
+			Size:       int64(len(object.data)),
+			Updated:    object.updated,
+			Generation: object.generation,
+		}
+		if match(&info) {
+			objects = append(objects, info)
+		}
+	}
+	return objects, prefixes, nil
+}
+
+func (m *mem) ListVersions(key string) ([]*ObjectVersion, error) {
+	if err := m.inject("ListVersions"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var result []*ObjectVersion
+	for _, object := range m.versions[key] {
+		result = append(result, toMemVersion(key, object, false))
+	}
+	if object, ok := m.objects[key]; ok {
+		result = append(result, toMemVersion(key, object, true))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Generation > result[j].Generation
+	})
+	return result, nil
+}
+
+func toMemVersion(key string, object *memObject, isLatest bool) *ObjectVersion {
+	return &ObjectVersion{
+		Key:        key,
+		Generation: object.generation,
+		Size:       int64(len(object.data)),
+		Updated:    object.updated,
+		IsLatest:   isLatest,
+	}
+}
+
+// findVersion 呼叫前需持有 m.mu
+func (m *mem) findVersion(key string, generation int64) *memObject {
+	if object, ok := m.objects[key]; ok && object.generation == generation {
+		return object
+	}
+	for _, object := range m.versions[key] {
+		if object.generation == generation {
+			return object
+		}
+	}
+	return nil
+}
+
+func (m *mem) GetVersion(key string, generation int64) ([]byte, error) {
+	if err := m.inject("GetVersion"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	object := m.findVersion(key, generation)
+	if object == nil {
+		return nil, notExist("GetVersion", fmt.Sprintf("%s#%d", key, generation))
+	}
+	return append([]byte{}, object.data...), nil
+}
+
+func (m *mem) RestoreVersion(key string, generation int64) (string, error) {
+	if err := m.inject("RestoreVersion"); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	object := m.findVersion(key, generation)
+	m.mu.RUnlock()
+	if object == nil {
+		return "", notExist("restore", fmt.Sprintf("%s#%d", key, generation))
+	}
+	return m.put(key, object.data, nil)
+}
+
+func (m *mem) DeleteVersion(key string, generation int64) error {
+	if err := m.inject("DeleteVersion"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if object, ok := m.objects[key]; ok && object.generation == generation {
+		delete(m.objects, key)
+		return nil
+	}
+	versions := m.versions[key]
+	for i, object := range versions {
+		if object.generation == generation {
+			m.versions[key] = append(versions[:i:i], versions[i+1:]...)
+			if len(m.versions[key]) == 0 {
+				delete(m.versions, key)
+			}
+			return nil
+		}
+	}
+	return notExist("delete", fmt.Sprintf("%s#%d", key, generation))
+}
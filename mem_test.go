@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestMemKeepVersions(t *testing.T) {
+	for _, keep := range []int{0, 2} {
+		sto := NewMemStorageWithOptions(MemOptions{KeepVersions: keep})
+		for i := 0; i < 5; i++ {
+			if _, err := sto.Save("a.txt", []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		versions, err := sto.ListVersions("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 目前版本加上保留的舊版本
+		if len(versions) != keep+1 {
+			t.Fatalf("keep %d: len(versions) = %d", keep, len(versions))
+		}
+		data, err := sto.GetVersion("a.txt", versions[len(versions)-1].Generation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := byte(4 - keep); data[0] != want {
+			t.Fatalf("keep %d: oldest version = %d, want %d", keep, data[0], want)
+		}
+	}
+}
+
+// TestMemFailOn 依呼叫次數注入錯誤，失敗的呼叫不變更內容
+func TestMemFailOn(t *testing.T) {
+	errSave, errAny := errors.New("save failed"), errors.New("any failed")
+	sto := NewMemStorage()
+	sto.FailOn("Save", 2, errSave)
+	if _, err := sto.Save("a.txt", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sto.Save("a.txt", []byte("2")); !errors.Is(err, errSave) {
+		t.Fatalf("second save = %v", err)
+	}
+	if _, err := sto.Save("a.txt", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := sto.Get("a.txt"); err != nil || string(data) != "3" {
+		t.Fatalf("a.txt = %q, %v", data, err)
+	}
+	if sto.Calls("Save") != 3 || sto.Calls("Get") != 1 || sto.Calls("") != 4 {
+		t.Fatalf("calls = %d save, %d get, %d total", sto.Calls("Save"), sto.Calls("Get"), sto.Calls(""))
+	}
+
+	// 空字串以所有方法的呼叫次數計算
+	sto.FailOn("", 6, errAny)
+	if _, err := sto.Get("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sto.Delete("a.txt"); !errors.Is(err, errAny) {
+		t.Fatalf("sixth call = %v", err)
+	}
+	if exist, err := sto.FileExist("a.txt"); err != nil || !exist {
+		t.Fatalf("a.txt exist = %v, %v", exist, err)
+	}
+
+	// n 為 0 時每次都失敗，ResetFaults 後恢復並重新計算次數
+	sto.FailOn("Get", 0, errSave)
+	for i := 0; i < 2; i++ {
+		if _, err := sto.Get("a.txt"); !errors.Is(err, errSave) {
+			t.Fatalf("get %d = %v", i, err)
+		}
+	}
+	sto.ResetFaults()
+	if _, err := sto.Get("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if sto.Calls("Get") != 1 || sto.Calls("") != 1 {
+		t.Fatalf("calls after reset = %d get, %d total", sto.Calls("Get"), sto.Calls(""))
+	}
+}
+
+// TestMemSetLatency 延遲套用於指定的方法，空字串套用於所有方法
+func TestMemSetLatency(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	sto := NewMemStorage()
+	elapsed := func(f func()) time.Duration {
+		start := time.Now()
+		f()
+		return time.Since(start)
+	}
+	sto.SetLatency("Save", delay)
+	if d := elapsed(func() { sto.Save("a.txt", []byte("a")) }); d < delay {
+		t.Fatalf("save took %v", d)
+	}
+	if d := elapsed(func() { sto.Get("a.txt") }); d >= delay {
+		t.Fatalf("get took %v", d)
+	}
+	sto.SetLatency("", delay)
+	if d := elapsed(func() { sto.Get("a.txt") }); d < delay {
+		t.Fatalf("get took %v", d)
+	}
+	if d := elapsed(func() { sto.Save("a.txt", []byte("a")) }); d < 2*delay {
+		t.Fatalf("save with both latencies took %v", d)
+	}
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const _s3_PartSize = 16 << 20
+
+type S3Storage interface {
+	Storage
+	ObjectLister
+	PrefixDeleter
+	MultipartUploader
+	ObjectReader
+	GetAttr(key string) (*minio.ObjectInfo, error)
+	Write(key string, writeData func(w io.Writer) error) (path string, err error)
+	OpenFile(key string) (io.Reader, error)
+	// PresignedGetURL 回傳 expDuration 內有效的下載連結
+	PresignedGetURL(key string, expDuration time.Duration) (url string, err error)
+	// PresignedPutURL 回傳 expDuration 內有效的上傳連結
+	PresignedPutURL(key string, expDuration time.Duration) (url string, err error)
+}
+
+// S3Conf 適用於 AWS S3 及 MinIO 等相容 S3 API 的服務
+type S3Conf struct {
+	// 例如 s3.ap-northeast-1.amazonaws.com 或 minio:9000，不含 scheme
+	Endpoint     string `yaml:"endpoint"`
+	Region       string `yaml:"region"`
+	AccessKey    string `yaml:"accessKey"`
+	SecretKey    string `yaml:"secretKey"`
+	SessionToken string `yaml:"sessionToken"`
+	Bucket       string `yaml:"bucket"`
+	// 使用 http 連線，只適用於本地測試
+	DisableSSL bool `yaml:"disableSSL"`
+	// 以 endpoint/bucket/key 存取，MinIO 通常需要開啟
+	PathStyle bool `yaml:"pathStyle"`
+	// SaveByReader 及 Write 分段上傳時每段的大小，每段會整段暫存於記憶體，預設 16MiB
+	PartSize uint64 `yaml:"partSize"`
+	// Save、SaveByReader 及 Write 不計算內容的 SHA-256，http 連線時不使用 aws-chunked 編碼，部分 S3 模擬服務需要開啟
+	UnsignedPayload bool `yaml:"unsignedPayload"`
+}
+
+func (conf *S3Conf) NewStorage(ctx context.Context) (S3Storage, error) {
+	lookup := minio.BucketLookupAuto
+	if conf.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, conf.SessionToken),
+		Secure:       !conf.DisableSSL,
+		Region:       conf.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("minio.New: %v", err)
+	}
+	return &s3Impl{
+		ctx:    ctx,
+		S3Conf: conf,
+		client: client,
+		core:   &minio.Core{Client: client},
+	}, nil
+}
+
+type s3Impl struct {
+	ctx context.Context
+	*S3Conf
+	client *minio.Client
+	core   *minio.Core
+}
+
+func isS3NotExist(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// s3Error 檔案不存在時同時包裝 os.ErrNotExist，與 hd 及 sftp 相同可以 errors.Is 判斷
+func s3Error(err error) error {
+	if isS3NotExist(err) {
+		return fmt.Errorf("%w: %w", os.ErrNotExist, err)
+	}
+	return err
+}
+
+func (s *s3Impl) Save(filePath string, file []byte) (string, error) {
+	return s.put(filePath, bytes.NewReader(file), int64(len(file)))
+}
+
+func (s *s3Impl) SaveByReader(fp string, reader io.Reader) (string, error) {
+	// 大小未知且內容為空時 http client 會改用 chunked 傳送，S3 會回傳 MissingContentLength
+	br := bufio.NewReader(reader)
+	if _, err := br.Peek(1); err == io.EOF {
+		return s.put(fp, bytes.NewReader(nil), 0)
+	}
+	return s.put(fp, br, -1)
+}
+
+// put 大小未知時 minio-go 會以 PartSize 分段上傳
+func (s *s3Impl) put(key string, reader io.Reader, size int64) (string, error) {
+	partSize := s.PartSize
+	if partSize == 0 {
+		partSize = _s3_PartSize
+	}
+	info, err := s.client.PutObject(s.ctx, s.Bucket, key, reader, size, minio.PutObjectOptions{
+		PartSize:             partSize,
+		DisableContentSha256: s.UnsignedPayload,
+	})
+	if err != nil {
+		return "", fmt.Errorf("createFile: bucket %q, file %q: %w", s.Bucket, key, err)
+	}
+	return info.Key, nil
+}
+
+func (s *s3Impl) Write(key string, writeData func(w io.Writer) error) (string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		if err := writeData(pw); err != nil {
+			pw.CloseWithError(fmt.Errorf("write file error: %w", err))
+			return
+		}
+		pw.Close()
+	}()
+	path, err := s.SaveByReader(key, pr)
+	pr.Close()
+	return path, err
+}
+
+// Delete 與 gcs 相同，檔案不存在時回傳錯誤
+func (s *s3Impl) Delete(key string) error {
+	if _, err := s.GetAttr(key); err != nil {
+		return fmt.Errorf("delete: bucket %q, file %q: %w", s.Bucket, key, s3Error(err))
+	}
+	if err := s.client.RemoveObject(s.ctx, s.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("delete: unable to delete object bucket %q, file %q: %w", s.Bucket, key, err)
+	}
+	return nil
+}
+
+func (s *s3Impl) Get(key string) ([]byte, error) {
+	object, err := s.client.GetObject(s.ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("GetObject(%q): %w", key, s3Error(err))
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("GetObject(%q): %w", key, s3Error(err))
+	}
+	return data, nil
+}
+
+func (s *s3Impl) NewRangeReader(key string, offset, length int64) (io.ReadCloser, error) {
+	// GetObject 在第一次讀取時才送出請求，先確認檔案存在讓錯誤立即回傳
+	if _, err := s.GetAttr(key); err != nil {
+		return nil, fmt.Errorf("GetObject(%q): %w", key, s3Error(err))
+	}
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	opts := minio.GetObjectOptions{}
+	if offset > 0 || length > 0 {
+		end := int64(0)
+		if length > 0 {
+			end = offset + length - 1
+		}
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, err
+		}
+	}
+	object, err := s.client.GetObject(s.ctx, s.Bucket, key, opts)
+	if err != nil {
+		return nil, fmt.Errorf("GetObject(%q): %w", key, s3Error(err))
+	}
+	return object, nil
+}
+
+func (s *s3Impl) OpenFile(key string) (io.Reader, error) {
+	data, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (s *s3Impl) GetAttr(key string) (*minio.ObjectInfo, error) {
+	info, err := s.client.StatObject(s.ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (s *s3Impl) FileExist(fp string) (bool, error) {
+	_, err := s.GetAttr(fp)
+	if isS3NotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *s3Impl) BatchDelete(keys []string) ([]BatchResult, error) {
+	return batchDelete(s, keys), nil
+}
+
+func (s *s3Impl) BatchExist(keys []string) ([]BatchResult, error) {
+	return batchExist(s, keys), nil
+}
+
+func (s *s3Impl) BatchStat(keys []string) ([]BatchResult, error) {
+	return batchStat(keys, func(key string) (*ObjectInfo, error) {
+		info, err := s.GetAttr(key)
+		if isS3NotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return toS3ObjectInfo(info), nil
+	}), nil
+}
+
+func (s *s3Impl) PresignedGetURL(key string, expDuration time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(s.ctx, s.Bucket, key, expDuration, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *s3Impl) PresignedPutURL(key string, expDuration time.Duration) (string, error) {
+	u, err := s.client.PresignedPutObject(s.ctx, s.Bucket, key, expDuration)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *s3Impl) NewMultipartUpload(key string) (string, error) {
+	return s.core.NewMultipartUpload(s.ctx, s.Bucket, key, minio.PutObjectOptions{})
+}
+
+func (s *s3Impl) UploadPart(key string, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	part, err := s.core.PutObjectPart(s.ctx, s.Bucket, key, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+func (s *s3Impl) CompleteMultipartUpload(key string, uploadID string, parts []CompletedPart) (string, error) {
+	completeParts := make([]minio.CompletePart, len(parts))
+	for i, p := range parts {
+		completeParts[i] = minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag}
+	}
+	info, err := s.core.CompleteMultipartUpload(s.ctx, s.Bucket, key, uploadID, completeParts, minio.PutObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	return info.Key, nil
+}
+
+func (s *s3Impl) AbortMultipartUpload(key string, uploadID string) error {
+	return s.core.AbortMultipartUpload(s.ctx, s.Bucket, key, uploadID)
+}
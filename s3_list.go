@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+)
+
+const _s3_MaxKeys = 1000
+
+// List 與 gcs 相同列出 dir 之下所有的檔案，不包含目錄
+func (s *s3Impl) List(dir string) ([]string, error) {
+	result := []string{}
+	for object := range s.client.ListObjects(s.ctx, s.Bucket, minio.ListObjectsOptions{
+		Prefix:    dir,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("Bucket(%q).ListObjects: %w", s.Bucket, object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		result = append(result, object.Key)
+	}
+	return result, nil
+}
+
+// ListObjects 的 page token 即為 S3 的 continuation token，MatchGlob 及 offset 在取回後篩選
+func (s *s3Impl) ListObjects(prefix string, opts *ListOptions) (*ListPage, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	match, err := opts.matcher(true)
+	if err != nil {
+		return nil, err
+	}
+	page := &ListPage{}
+	token := opts.PageToken
+	for {
+		maxKeys := _s3_MaxKeys
+		if opts.PageSize > 0 {
+			maxKeys = opts.PageSize
+		}
+		result, err := s.core.ListObjectsV2(s.Bucket, prefix, "", token, opts.delimiter(), maxKeys)
+		if err != nil {
+			return nil, fmt.Errorf("Bucket(%q).ListObjectsV2: %w", s.Bucket, err)
+		}
+		s.appendListResult(page, &result, opts, match)
+		token = result.NextContinuationToken
+		if !result.IsTruncated || token == "" {
+			return page, nil
+		}
+		if opts.PageSize > 0 {
+			page.NextToken = token
+			return page, nil
+		}
+	}
+}
+
+func (s *s3Impl) appendListResult(page *ListPage, result *minio.ListBucketV2Result, opts *ListOptions, match func(o *ObjectInfo) bool) {
+	for _, p := range result.CommonPrefixes {
+		if opts.inRange(p.Prefix) {
+			page.Prefixes = append(page.Prefixes, p.Prefix)
+		}
+	}
+	for i := range result.Contents {
+		object := toS3ObjectInfo(&result.Contents[i])
+		// 略過目錄的佔位物件
+		if strings.HasSuffix(object.Key, "/") || !opts.inRange(object.Key) || !match(object) {
+			continue
+		}
+		page.Objects = append(page.Objects, *object)
+	}
+}
+
+func (s *s3Impl) Objects(prefix string, opts *ListOptions) ObjectIterator {
+	all := &ListOptions{}
+	if opts != nil {
+		*all = *opts
+	}
+	all.PageToken = ""
+	return newChanIterator(func(yield func(*ObjectInfo) error) error {
+		for {
+			// 每次取回一頁再依 key 順序交給 yield
+			page, err := s.ListObjects(prefix, &ListOptions{
+				Delimiter:   all.Delimiter,
+				Recursive:   all.Recursive,
+				PageSize:    _s3_MaxKeys,
+				PageToken:   all.PageToken,
+				StartOffset: all.StartOffset,
+				EndOffset:   all.EndOffset,
+				MatchGlob:   all.MatchGlob,
+				Filter:      all.Filter,
+			})
+			if err != nil {
+				return err
+			}
+			for _, object := range mergePage(page) {
+				if err = yield(object); err != nil {
+					return err
+				}
+			}
+			if page.NextToken == "" {
+				return nil
+			}
+			all.PageToken = page.NextToken
+		}
+	})
+}
+
+func (s *s3Impl) ListMatch(pattern string, filters ...ListFilter) ([]ObjectInfo, error) {
+	return listMatch(s, pattern, filters...)
+}
+
+func toS3ObjectInfo(object *minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:     object.Key,
+		Size:    object.Size,
+		Updated: object.LastModified,
+	}
+}
+
+// DeletePrefix 列出 prefix 之下所有物件後批次刪除
+func (s *s3Impl) DeletePrefix(prefix string) error {
+	if prefix == "" {
+		return errors.Wrap(ErrInvalidKey, "prefix can not be empty")
+	}
+	objects := s.client.ListObjects(s.ctx, s.Bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+	var listErr error
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for object := range objects {
+			if object.Err != nil {
+				listErr = object.Err
+				continue
+			}
+			objectsCh <- object
+		}
+	}()
+	var deleteErr error
+	for result := range s.client.RemoveObjects(s.ctx, s.Bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil && deleteErr == nil {
+			deleteErr = fmt.Errorf("delete: unable to delete object bucket %q, file %q: %v", s.Bucket, result.ObjectName, result.Err)
+		}
+	}
+	if listErr != nil {
+		return fmt.Errorf("Bucket(%q).ListObjects: %w", s.Bucket, listErr)
+	}
+	return deleteErr
+}
@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	_sftp_DialTimeout = 10 * time.Second
+	// 寫入中的暫存檔名稱，List 不會列出
+	_sftp_TempPrefix = ".sftp-tmp-"
+)
+
+// SftpStorage 透過 SSH 存取遠端目錄，連線保留在 pool 中重複使用
+type SftpStorage interface {
+	Storage
+	// Close 關閉所有連線，之後的操作皆回傳錯誤
+	Close() error
+}
+
+type SftpConf struct {
+	// host:port，未指定 port 時為 22
+	Host     string `yaml:"host"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// PEM 格式的私鑰，與 PrivateKeyFile 擇一
+	PrivateKey     string `yaml:"privateKey"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	Passphrase     string `yaml:"passphrase"`
+	// authorized_keys 格式的主機公鑰，HostKey、KnownHostsFile 及 InsecureIgnoreHostKey 須擇一
+	HostKey               string `yaml:"hostKey"`
+	KnownHostsFile        string `yaml:"knownHostsFile"`
+	InsecureIgnoreHostKey bool   `yaml:"insecureIgnoreHostKey"`
+	// 遠端的根目錄，未指定時為登入後的目錄
+	Root string `yaml:"root"`
+	// 同時使用的連線數上限，預設 4
+	MaxConns    int           `yaml:"maxConns"`
+	DialTimeout time.Duration `yaml:"dialTimeout"`
+}
+
+// NewStorage 不會立即連線，第一次操作時才建立連線。ctx 結束時關閉所有連線
+func (conf *SftpConf) NewStorage(ctx context.Context) (SftpStorage, error) {
+	config, err := conf.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	s := &sftpImpl{
+		SftpConf: conf,
+		config:   config,
+	}
+	s.pool = newSftpPool(conf.MaxConns, s.dial)
+	context.AfterFunc(ctx, func() {
+		s.Close()
+	})
+	return s, nil
+}
+
+func (conf *SftpConf) clientConfig() (*ssh.ClientConfig, error) {
+	var auths []ssh.AuthMethod
+	signer, err := conf.signer()
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if conf.Password != "" {
+		auths = append(auths, ssh.Password(conf.Password))
+	}
+	if len(auths) == 0 {
+		return nil, errors.New("sftp: password or private key required")
+	}
+	hostKeyCallback, err := conf.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	timeout := conf.DialTimeout
+	if timeout == 0 {
+		timeout = _sftp_DialTimeout
+	}
+	return &ssh.ClientConfig{
+		User:            conf.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, nil
+}
+
+func (conf *SftpConf) signer() (ssh.Signer, error) {
+	key := []byte(conf.PrivateKey)
+	if conf.PrivateKeyFile != "" {
+		data, err := os.ReadFile(conf.PrivateKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "sftp: read private key")
+		}
+		key = data
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+	var signer ssh.Signer
+	var err error
+	if conf.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(conf.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	return signer, errors.Wrap(err, "sftp: parse private key")
+}
+
+func (conf *SftpConf) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch {
+	case conf.HostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(conf.HostKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "sftp: parse host key")
+		}
+		return ssh.FixedHostKey(key), nil
+	case conf.KnownHostsFile != "":
+		callback, err := knownhosts.New(conf.KnownHostsFile)
+		return callback, errors.Wrap(err, "sftp: load known hosts")
+	case conf.InsecureIgnoreHostKey:
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return nil, errors.New("sftp: hostKey, knownHostsFile or insecureIgnoreHostKey required")
+}
+
+func (conf *SftpConf) addr() string {
+	if _, _, err := net.SplitHostPort(conf.Host); err != nil {
+		return net.JoinHostPort(conf.Host, "22")
+	}
+	return conf.Host
+}
+
+type sftpImpl struct {
+	*SftpConf
+	config *ssh.ClientConfig
+	pool   *sftpPool
+}
+
+func (s *sftpImpl) dial() (*sftpConn, error) {
+	client, err := ssh.Dial("tcp", s.addr(), s.config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sftp: dial %s", s.Host)
+	}
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "sftp: start subsystem %s", s.Host)
+	}
+	conn := &sftpConn{ssh: client, client: sc}
+	go func() {
+		client.Wait()
+		conn.lost.Store(true)
+	}()
+	return conn, nil
+}
+
+func (s *sftpImpl) Close() error {
+	return s.pool.close()
+}
+
+func (s *sftpImpl) remotePath(key string) string {
+	p := path.Join(s.Root, key)
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (s *sftpImpl) Save(filePath string, file []byte) (string, error) {
+	return s.SaveByReader(filePath, bytes.NewReader(file))
+}
+
+// SaveByReader 先寫入同目錄的暫存檔再更名，讀取端不會看到寫到一半的檔案
+func (s *sftpImpl) SaveByReader(fp string, reader io.Reader) (string, error) {
+	if err := validateKey(fp); err != nil {
+		return "", err
+	}
+	remote := s.remotePath(fp)
+	err := s.pool.do(func(c *sftp.Client) error {
+		dir := path.Dir(remote)
+		if err := c.MkdirAll(dir); err != nil {
+			return err
+		}
+		suffix, err := randomHex(8)
+		if err != nil {
+			return err
+		}
+		tmp := path.Join(dir, _sftp_TempPrefix+suffix)
+		f, err := c.Create(tmp)
+		if err != nil {
+			return err
+		}
+		_, err = f.ReadFrom(reader)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = sftpRename(c, tmp, remote)
+		}
+		if err != nil {
+			c.Remove(tmp)
+		}
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "sftp: save %q", fp)
+	}
+	return remote, nil
+}
+
+// sftpRename 伺服器不支援 posix-rename 時先刪除目標檔案再更名
+func sftpRename(c *sftp.Client, from, to string) error {
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		return c.PosixRename(from, to)
+	}
+	if err := c.Remove(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.Rename(from, to)
+}
+
+// Delete 刪除檔案後一併移除空的上層目錄，檔案不存在時回傳錯誤
+func (s *sftpImpl) Delete(filePath string) error {
+	if err := validateKey(filePath); err != nil {
+		return err
+	}
+	remote := s.remotePath(filePath)
+	err := s.pool.do(func(c *sftp.Client) error {
+		info, err := c.Lstat(remote)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.ErrNotExist
+		}
+		if err = c.Remove(remote); err != nil {
+			return err
+		}
+		root := s.remotePath("")
+		for dir := path.Dir(remote); dir != root && dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if c.RemoveDirectory(dir) != nil {
+				break
+			}
+		}
+		return nil
+	})
+	return errors.Wrapf(err, "sftp: delete %q", filePath)
+}
+
+func (s *sftpImpl) Get(fp string) ([]byte, error) {
+	if err := validateKey(fp); err != nil {
+		return nil, err
+	}
+	var data []byte
+	err := s.pool.do(func(c *sftp.Client) error {
+		f, err := c.Open(s.remotePath(fp))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err = io.ReadAll(f)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "sftp: get %q", fp)
+	}
+	return data, nil
+}
+
+func (s *sftpImpl) FileExist(fp string) (bool, error) {
+	if err := validateKey(fp); err != nil {
+		return false, err
+	}
+	var exist bool
+	err := s.pool.do(func(c *sftp.Client) error {
+		info, err := c.Stat(s.remotePath(fp))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		exist = !info.IsDir()
+		return nil
+	})
+	return exist, errors.Wrapf(err, "sftp: stat %q", fp)
+}
+
+func (s *sftpImpl) BatchDelete(keys []string) ([]BatchResult, error) {
+	return batchDelete(s, keys), nil
+}
+
+func (s *sftpImpl) BatchExist(keys []string) ([]BatchResult, error) {
+	return batchExist(s, keys), nil
+}
+
+// BatchStat 同時使用的連線數受 MaxConns 限制
+func (s *sftpImpl) BatchStat(keys []string) ([]BatchResult, error) {
+	return batchStat(keys, func(key string) (*ObjectInfo, error) {
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+		var object *ObjectInfo
+		err := s.pool.do(func(c *sftp.Client) error {
+			info, err := c.Stat(s.remotePath(key))
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				object = &ObjectInfo{Key: key, Size: info.Size(), Updated: info.ModTime()}
+			}
+			return nil
+		})
+		return object, errors.Wrapf(err, "sftp: stat %q", key)
+	}), nil
+}
+
+// List 與 hd 相同只列出一層，子目錄以 "/" 結尾
+func (s *sftpImpl) List(dir string) ([]string, error) {
+	if err := validatePrefix(dir); err != nil {
+		return nil, err
+	}
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir = dir + "/"
+	}
+	var result []string
+	err := s.pool.do(func(c *sftp.Client) error {
+		files, err := c.ReadDir(s.remotePath(dir))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), _sftp_TempPrefix) {
+				continue
+			}
+			if f.IsDir() {
+				result = append(result, dir+f.Name()+"/")
+			} else {
+				result = append(result, dir+f.Name())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "sftp: list %q", dir)
+	}
+	sort.Strings(result)
+	return result, nil
+}
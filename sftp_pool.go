@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const _sftp_MaxConns = 4
+
+var errSftpClosed = errors.New("sftp: storage closed")
+
+type sftpConn struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+	// 伺服器斷線後由 dial 啟動的 goroutine 設為 true
+	lost atomic.Bool
+}
+
+func (c *sftpConn) close() {
+	c.client.Close()
+	c.ssh.Close()
+}
+
+// sftpPool 限制同時使用的連線數，用完的連線放回 idle 重複使用，斷線的連線直接丟棄
+type sftpPool struct {
+	dial  func() (*sftpConn, error)
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*sftpConn
+	closed bool
+}
+
+func newSftpPool(maxConns int, dial func() (*sftpConn, error)) *sftpPool {
+	if maxConns <= 0 {
+		maxConns = _sftp_MaxConns
+	}
+	return &sftpPool{
+		dial:  dial,
+		slots: make(chan struct{}, maxConns),
+	}
+}
+
+// do 取得一個連線執行 fn，連線數已達上限時等待其他操作結束
+func (p *sftpPool) do(fn func(c *sftp.Client) error) error {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	conn, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = fn(conn.client)
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		conn.lost.Store(true)
+	}
+	p.put(conn)
+	return err
+}
+
+func (p *sftpPool) get() (*sftpConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errSftpClosed
+	}
+	for len(p.idle) > 0 {
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !conn.lost.Load() {
+			p.mu.Unlock()
+			return conn, nil
+		}
+		conn.close()
+	}
+	p.mu.Unlock()
+	return p.dial()
+}
+
+func (p *sftpPool) put(conn *sftpConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || conn.lost.Load() {
+		conn.close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+}
+
+// close 關閉閒置的連線，使用中的連線在操作結束時關閉
+func (p *sftpPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, conn := range p.idle {
+		conn.close()
+	}
+	p.idle = nil
+	return nil
+}
@@ -1,6 +1,21 @@
 package storage
 
-import "io"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrInvalidKey         = errors.New("invalid key")
+	ErrQuotaExceeded      = errors.New("quota exceeded")
+)
 
 type Storage interface {
 	Save(filePath string, file []byte) (string, error)
@@ -10,3 +25,95 @@ type Storage interface {
 	FileExist(fp string) (bool, error)
 	List(dir string) ([]string, error)
 }
+
+// ConditionalStorage 以 generation 作為前置條件進行寫入與刪除，
+// generation 為 0 代表物件不存在。
+type ConditionalStorage interface {
+	Generation(key string) (int64, error)
+	SaveIfNotExists(key string, file []byte) (string, error)
+	SaveIfMatch(key string, file []byte, generation int64) (string, error)
+	DeleteIfMatch(key string, generation int64) error
+}
+
+type ObjectVersion struct {
+	Key        string
+	Generation int64
+	Size       int64
+	Updated    time.Time
+	IsLatest   bool
+}
+
+// ObjectReader 以串流方式讀取物件的一部分，不需將整個檔案載入記憶體
+type ObjectReader interface {
+	// NewRangeReader 由 offset 開始讀取 length bytes，length 為 -1 時讀到檔案結尾
+	NewRangeReader(key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// byteRange 回傳 data 中 NewRangeReader 所指定的範圍
+func byteRange(data []byte, offset, length int64) []byte {
+	data = data[min(offset, int64(len(data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return data
+}
+
+// randomHex 回傳 n 個隨機 bytes 的十六進位字串，用於 lock token 及暫存檔名稱
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "read random bytes")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type PrefixDeleter interface {
+	// DeletePrefix 刪除 prefix 之下所有的物件，prefix 不可為空
+	DeletePrefix(prefix string) error
+}
+
+// VersionedStorage 存取物件的歷史版本
+type VersionedStorage interface {
+	ListVersions(key string) ([]*ObjectVersion, error)
+	GetVersion(key string, generation int64) ([]byte, error)
+	RestoreVersion(key string, generation int64) (string, error)
+	DeleteVersion(key string, generation int64) error
+}
+
+// MultipartUploader 分段上傳大檔案，所有段落上傳後呼叫 CompleteMultipartUpload 合併
+type MultipartUploader interface {
+	NewMultipartUpload(key string) (uploadID string, err error)
+	// UploadPart 回傳的 etag 用於 CompleteMultipartUpload，partNumber 由 1 開始
+	UploadPart(key string, uploadID string, partNumber int, reader io.Reader, size int64) (etag string, err error)
+	CompleteMultipartUpload(key string, uploadID string, parts []CompletedPart) (string, error)
+	AbortMultipartUpload(key string, uploadID string) error
+}
+
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// validateKey 拒絕空字串、以 "/" 開頭或結尾、含有 NUL 或 "."、".." 及空白路徑片段的 key
+func validateKey(key string) error {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return errors.Wrapf(ErrInvalidKey, "%q", key)
+	}
+	return validatePrefix(key)
+}
+
+// validatePrefix 與 validateKey 相同，但允許空字串及以 "/" 結尾的目錄
+func validatePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if strings.ContainsRune(prefix, 0) || strings.HasPrefix(prefix, "/") || filepath.IsAbs(prefix) {
+		return errors.Wrapf(ErrInvalidKey, "%q", prefix)
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.Wrapf(ErrInvalidKey, "%q", prefix)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,832 @@
+package storagetest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/94peter/storage"
+	"github.com/94peter/storage/container/service"
+	"github.com/94peter/storage/grpc/pb"
+	"github.com/94peter/storage/storagetest"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestHd(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return storage.NewHdStorage(t.TempDir())
+	})
+}
+
+func TestHdSharded(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return storage.NewHdStorageWithOptions(t.TempDir(), storage.HdOptions{ShardLevels: 2})
+	})
+}
+
+func TestHdDedup(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return storage.NewDedupHdStorage(t.TempDir(), storage.HdOptions{KeepVersions: 2})
+	})
+}
+
+func TestMem(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemStorage()
+	})
+}
+
+// skipUnsupported 略過 fake-gcs-server 未實作的功能
+func skipUnsupported(t *testing.T) {
+	if strings.HasSuffix(t.Name(), "/ConditionalDelete") {
+		t.Skip("fake-gcs-server ignores ifGenerationMatch on delete")
+	}
+}
+
+// newFakeGcs 啟動 fake-gcs-server，回傳的函式每次建立一個新的 bucket
+func newFakeGcs(t *testing.T) func(t *testing.T) *storage.GcpConf {
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+
+	var count int32
+	return func(t *testing.T) *storage.GcpConf {
+		skipUnsupported(t)
+		bucket := fmt.Sprintf("bucket-%d", atomic.AddInt32(&count, 1))
+		server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: bucket})
+		return &storage.GcpConf{
+			Bucket:   bucket,
+			Endpoint: server.URL() + "/storage/v1/",
+			NoAuth:   true,
+		}
+	}
+}
+
+func TestGcs(t *testing.T) {
+	newConf := newFakeGcs(t)
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		s, err := newConf(t).NewStorage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// newFlakyGcs 在 fake-gcs-server 前加上代理，fail 大於 0 時回傳 503 並減一，calls 為收到的請求數
+func newFlakyGcs(t *testing.T, retry *storage.GcpRetryConf) (sto storage.GcpStorage, fail, calls *int32) {
+	conf := newFakeGcs(t)(t)
+	target, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail, calls = new(int32), new(int32)
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: target.Scheme, Host: target.Host})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if atomic.AddInt32(fail, -1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		atomic.StoreInt32(fail, 0)
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	conf.Endpoint = server.URL + target.Path
+	conf.Retry = retry
+	sto, err = conf.NewStorage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sto, fail, calls
+}
+
+func TestGcsRetry(t *testing.T) {
+	backoff := func(conf storage.GcpRetryConf) *storage.GcpRetryConf {
+		conf.InitialBackoff, conf.MaxBackoff = time.Millisecond, 5*time.Millisecond
+		return &conf
+	}
+	t.Run("Idempotent", func(t *testing.T) {
+		sto, fail, _ := newFlakyGcs(t, backoff(storage.GcpRetryConf{}))
+		if _, err := sto.Save("a.txt", []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(fail, 2)
+		if data, err := sto.Get("a.txt"); err != nil || string(data) != "a" {
+			t.Fatalf("Get = %q, %v", data, err)
+		}
+		// 沒有前置條件的寫入不重試
+		atomic.StoreInt32(fail, 1)
+		if _, err := sto.Save("a.txt", []byte("b")); err == nil {
+			t.Fatal("unconditional save should not be retried")
+		}
+		atomic.StoreInt32(fail, 1)
+		if _, err := sto.SaveIfNotExists("b.txt", []byte("b")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("ConditionalWrites", func(t *testing.T) {
+		sto, fail, _ := newFlakyGcs(t, backoff(storage.GcpRetryConf{ConditionalWrites: true}))
+		for _, data := range []string{"a", "b"} {
+			atomic.StoreInt32(fail, 1)
+			if _, err := sto.Save("a.txt", []byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if data, _ := sto.Get("a.txt"); string(data) != "b" {
+			t.Fatalf("Get = %q, want b", data)
+		}
+		atomic.StoreInt32(fail, 1)
+		if err := sto.Delete("a.txt"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Always", func(t *testing.T) {
+		sto, fail, _ := newFlakyGcs(t, backoff(storage.GcpRetryConf{Policy: storage.GcpRetryAlways}))
+		atomic.StoreInt32(fail, 1)
+		if _, err := sto.Save("a.txt", []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Never", func(t *testing.T) {
+		sto, fail, calls := newFlakyGcs(t, backoff(storage.GcpRetryConf{Policy: storage.GcpRetryNever}))
+		atomic.StoreInt32(fail, 1)
+		if _, err := sto.FileExist("a.txt"); err == nil {
+			t.Fatal("expected error")
+		}
+		if n := atomic.LoadInt32(calls); n != 1 {
+			t.Fatalf("calls = %d, want 1", n)
+		}
+	})
+	t.Run("MaxAttempts", func(t *testing.T) {
+		sto, fail, calls := newFlakyGcs(t, backoff(storage.GcpRetryConf{MaxAttempts: 3}))
+		atomic.StoreInt32(fail, 10)
+		if _, err := sto.FileExist("a.txt"); err == nil {
+			t.Fatal("expected error")
+		}
+		if n := atomic.LoadInt32(calls); n != 3 {
+			t.Fatalf("calls = %d, want 3", n)
+		}
+	})
+	t.Run("InvalidPolicy", func(t *testing.T) {
+		conf := &storage.GcpConf{NoAuth: true, Retry: &storage.GcpRetryConf{Policy: "sometimes"}}
+		if _, err := conf.NewStorage(context.Background()); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+// newGrpcStorage 以 bufconn 啟動 gRPC 服務，回傳連線到 channel 的 client
+func newGrpcStorage(t *testing.T, channel *storage.ChannelConf) storage.Storage {
+	return newGrpcClient(t, channel, "test")
+}
+
+// newGrpcClient 服務只有 test channel，client 連線到 name
+func newGrpcClient(t *testing.T, channel *storage.ChannelConf, name string, opts ...grpc.ServerOption) storage.GrpcGcpStorage {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(opts...)
+	pb.RegisterGcpServiceServer(server, service.NewGcp(&storage.Config{
+		Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{"test": channel}),
+	}))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := storage.NewGrpcGcpStorageWithConn(context.Background(), conn, name)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// TestGrpcErrorReason 只有服務標示 reason 的錯誤會轉為 ErrInvalidKey，channel 不存在等其他 InvalidArgument 維持原本的 status
+func TestGrpcErrorReason(t *testing.T) {
+	channel := &storage.ChannelConf{Type: storage.ChannelHd, Hd: &storage.HdConf{Path: t.TempDir()}}
+
+	_, err := newGrpcClient(t, channel, "test").Save("../escape.txt", []byte("x"))
+	if !errors.Is(err, storage.ErrInvalidKey) {
+		t.Fatalf("Save escaping key = %v, want ErrInvalidKey", err)
+	}
+	results, err := newGrpcClient(t, channel, "test").(storage.BatchStorage).BatchExist([]string{"../escape.txt"})
+	if err != nil || !errors.Is(results[0].Err, storage.ErrInvalidKey) {
+		t.Fatalf("BatchExist escaping key = %+v, %v, want ErrInvalidKey", results, err)
+	}
+
+	_, err = newGrpcClient(t, channel, "missing").Save("a.txt", []byte("x"))
+	if err == nil || errors.Is(err, storage.ErrInvalidKey) {
+		t.Fatalf("Save to unknown channel = %v, want an error other than ErrInvalidKey", err)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Save to unknown channel code = %s, want InvalidArgument", status.Code(err))
+	}
+}
+
+// TestGrpcQuotaReason 超過 hd 配額為 ErrQuotaExceeded，grpc 本身因訊息過大回傳的 ResourceExhausted 則不是
+func TestGrpcQuotaReason(t *testing.T) {
+	channel := &storage.ChannelConf{Type: storage.ChannelHd, Hd: &storage.HdConf{
+		Path:      t.TempDir(),
+		HdOptions: storage.HdOptions{MaxBytes: 100},
+	}}
+	sto := newGrpcClient(t, channel, "test", grpc.MaxRecvMsgSize(1<<10))
+
+	_, err := sto.Save("quota.bin", make([]byte, 200))
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("Save over quota = %v, want ErrQuotaExceeded", err)
+	}
+	_, err = sto.Save("large.bin", make([]byte, 2<<10))
+	if err == nil || errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("Save over max message size = %v, want an error other than ErrQuotaExceeded", err)
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Save over max message size code = %s, want ResourceExhausted", status.Code(err))
+	}
+}
+
+func TestGrpc(t *testing.T) {
+	newConf := newFakeGcs(t)
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return newGrpcStorage(t, &storage.ChannelConf{Type: storage.ChannelGcs, Gcs: newConf(t)})
+	})
+}
+
+func TestGrpcHd(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return newGrpcStorage(t, &storage.ChannelConf{Type: storage.ChannelHd, Hd: &storage.HdConf{Path: t.TempDir()}})
+	})
+}
+
+func TestGrpcMemory(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return newGrpcStorage(t, &storage.ChannelConf{Type: storage.ChannelMemory})
+	})
+}
+
+// TestGrpcTimePrecision 物件時間及篩選條件經過 gRPC 後不會被截斷為秒
+func TestGrpcTimePrecision(t *testing.T) {
+	dir := t.TempDir()
+	sto := newGrpcStorage(t, &storage.ChannelConf{Type: storage.ChannelHd, Hd: &storage.HdConf{Path: dir}})
+	if _, err := sto.Save("a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	local, err := storage.NewHdStorage(dir).ListObjects("a.txt", nil)
+	if err != nil || len(local.Objects) != 1 {
+		t.Fatalf("local ListObjects = %+v, %v", local, err)
+	}
+	updated := local.Objects[0].Updated
+	if updated.Nanosecond() == 0 {
+		t.Skip("file system without sub-second mtime")
+	}
+
+	lister := sto.(storage.ObjectLister)
+	page, err := lister.ListObjects("a.txt", nil)
+	if err != nil || len(page.Objects) != 1 {
+		t.Fatalf("ListObjects = %+v, %v", page, err)
+	}
+	if !page.Objects[0].Updated.Equal(updated) {
+		t.Fatalf("Updated = %v, want %v", page.Objects[0].Updated, updated)
+	}
+	for _, tt := range []struct {
+		filter storage.ListFilter
+		want   int
+	}{
+		{storage.UpdatedAfter(updated.Add(-time.Nanosecond)), 1},
+		{storage.UpdatedAfter(updated), 0},
+		{storage.UpdatedBefore(updated.Add(time.Nanosecond)), 1},
+		{storage.UpdatedBefore(updated), 0},
+	} {
+		page, err := lister.ListObjects("a.txt", &storage.ListOptions{Filter: tt.filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Objects) != tt.want {
+			t.Fatalf("filter %+v: got %d objects, want %d", tt.filter, len(page.Objects), tt.want)
+		}
+	}
+}
+
+// TestGrpcBatchSize 超過服務上限的 key 分成多個 rpc，結果仍依原本的順序
+func TestGrpcBatchSize(t *testing.T) {
+	sto := newGrpcStorage(t, &storage.ChannelConf{Type: storage.ChannelMemory})
+	keys := make([]string, 2500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("batch/%04d.txt", i)
+	}
+	if _, err := sto.Save(keys[1234], []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	results, err := sto.(storage.BatchStorage).BatchExist(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(keys) {
+		t.Fatalf("got %d results, want %d", len(results), len(keys))
+	}
+	for i, r := range results {
+		if r.Key != keys[i] || r.Exist != (i == 1234) || r.Err != nil {
+			t.Fatalf("result %d = %+v", i, r)
+		}
+	}
+}
+
+// writeCerts 產生 CA 及以其簽發的 localhost 伺服器憑證與 client 憑證，回傳 PEM 檔的路徑
+func writeCerts(t *testing.T) (ca, serverCert, serverKey, clientCert, clientKey string) {
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	write := func(name, typ string, der []byte) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	issue := func(name string, template *x509.Certificate) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return write(name+".crt", "CERTIFICATE", der), write(name+".key", "EC PRIVATE KEY", keyDer)
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca = write("ca.crt", "CERTIFICATE", caDer)
+	serverCert, serverKey = issue("server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert, clientKey = issue("client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return
+}
+
+// TestGrpcTls 以 mTLS 及 api key 連線到啟用驗證的 grpc 服務
+func TestGrpcTls(t *testing.T) {
+	ca, serverCert, serverKey, clientCert, clientKey := writeCerts(t)
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		cfg := &storage.Config{
+			Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{"test": {
+				Type:     storage.ChannelMemory,
+				Policies: []storage.ChannelPolicy{{Identities: []string{"key:test"}, Operations: []string{"*"}}},
+			}}),
+		}
+		auth, err := service.NewGrpcAuth(&service.GrpcAuthConf{
+			ApiKeys: []service.ApiKeyConf{{Identity: "test", Key: "secret"}},
+			Tls:     &service.TlsConf{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: ca},
+		}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer(auth.ServerOptions()...)
+		pb.RegisterGcpServiceServer(server, service.NewGcp(cfg))
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+
+		s, err := storage.NewGrpcGcpStorageWithOptions(context.Background(), lis.Addr().String(), "test", storage.GrpcClientOptions{
+			CAFile:            ca,
+			CertFile:          clientCert,
+			KeyFile:           clientKey,
+			ServerName:        "localhost",
+			PerRPCCredentials: storage.ApiKeyCredentials{Key: "secret"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		return s
+	})
+}
+
+func TestGrpcDialTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 接受連線但不回應 http2 handshake
+	t.Cleanup(func() { lis.Close() })
+
+	start := time.Now()
+	_, err = storage.NewGrpcGcpStorageWithOptions(context.Background(), lis.Addr().String(), "test", storage.GrpcClientOptions{
+		DialTimeout: 200 * time.Millisecond,
+	})
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("blocking dial = %v after %s, want timeout", err, time.Since(start))
+	}
+
+	s, err := storage.NewGrpcGcpStorageWithOptions(context.Background(), lis.Addr().String(), "test", storage.GrpcClientOptions{
+		NonBlocking: true,
+	})
+	if err != nil {
+		t.Fatalf("non-blocking dial: %v", err)
+	}
+	s.Close()
+}
+
+// newFlakyGrpcServer 每個 rpc 執行前呼叫 inject 模擬服務異常，回傳位址及呼叫次數
+func newFlakyGrpcServer(t *testing.T, inject func(call int32) error) (string, *int32) {
+	var calls int32
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := inject(atomic.AddInt32(&calls, 1)); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}))
+	pb.RegisterGcpServiceServer(server, service.NewGcp(&storage.Config{
+		Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{"test": {Type: storage.ChannelMemory}}),
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String(), &calls
+}
+
+func newResilientGrpcStorage(t *testing.T, addr string, opts storage.GrpcClientOptions) storage.GrpcGcpStorage {
+	s, err := storage.NewGrpcGcpStorageWithOptions(context.Background(), addr, "test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+var errInjected = status.Error(codes.Unavailable, "injected failure")
+
+func TestGrpcRetry(t *testing.T) {
+	addr, calls := newFlakyGrpcServer(t, func(call int32) error {
+		if call == 2 || call == 3 {
+			return errInjected
+		}
+		return nil
+	})
+	s := newResilientGrpcStorage(t, addr, storage.GrpcClientOptions{
+		Retry: &storage.GrpcRetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	if _, err := s.Save("a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Get("a.txt"); err != nil || string(data) != "a" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if n := atomic.LoadInt32(calls); n != 4 {
+		t.Fatalf("calls = %d, want 4", n)
+	}
+
+	// SaveFile 不是冪等的 rpc，不重試
+	addr, calls = newFlakyGrpcServer(t, func(call int32) error {
+		if call == 1 {
+			return errInjected
+		}
+		return nil
+	})
+	s = newResilientGrpcStorage(t, addr, storage.GrpcClientOptions{
+		Retry: &storage.GrpcRetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	if _, err := s.Save("a.txt", []byte("a")); status.Code(err) != codes.Unavailable || atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("Save = %v after %d calls, want Unavailable after 1", err, atomic.LoadInt32(calls))
+	}
+}
+
+func TestGrpcCallTimeout(t *testing.T) {
+	// 第一次呼叫超過 CallTimeout
+	addr, _ := newFlakyGrpcServer(t, func(call int32) error {
+		if call == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return nil
+	})
+	s := newResilientGrpcStorage(t, addr, storage.GrpcClientOptions{CallTimeout: 50 * time.Millisecond})
+	if _, err := s.FileExist("a.txt"); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("FileExist = %v, want DeadlineExceeded", err)
+	}
+
+	addr, _ = newFlakyGrpcServer(t, func(call int32) error {
+		if call == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return nil
+	})
+	s = newResilientGrpcStorage(t, addr, storage.GrpcClientOptions{
+		CallTimeout: 50 * time.Millisecond,
+		Retry:       &storage.GrpcRetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	if _, err := s.FileExist("a.txt"); err != nil {
+		t.Fatalf("FileExist with retry = %v", err)
+	}
+}
+
+func TestGrpcCircuitBreaker(t *testing.T) {
+	addr, calls := newFlakyGrpcServer(t, func(call int32) error { return errInjected })
+	var opened int32
+	s := newResilientGrpcStorage(t, addr, storage.GrpcClientOptions{
+		CircuitBreaker: &storage.GrpcCircuitBreaker{
+			ConsecutiveFailures: 2,
+			OpenTimeout:         time.Hour,
+			OnStateChange: func(address, from, to string) {
+				if to == "open" {
+					atomic.AddInt32(&opened, 1)
+				}
+			},
+		},
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := s.FileExist("a.txt"); status.Code(err) != codes.Unavailable {
+			t.Fatalf("FileExist = %v, want Unavailable", err)
+		}
+	}
+	if _, err := s.FileExist("a.txt"); !errors.Is(err, storage.ErrCircuitOpen) {
+		t.Fatalf("FileExist = %v, want ErrCircuitOpen", err)
+	}
+	if n := atomic.LoadInt32(calls); n != 2 || atomic.LoadInt32(&opened) != 1 {
+		t.Fatalf("calls = %d, opened = %d", n, opened)
+	}
+}
+
+func TestS3(t *testing.T) {
+	newStorage := newS3Storage(t)
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return newStorage(t)
+	})
+}
+
+// TestS3NotExist 檔案不存在時與 hd 相同回傳 os.ErrNotExist
+func TestS3NotExist(t *testing.T) {
+	s := newS3Storage(t)(t)
+	if _, err := s.Get("missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Get = %v", err)
+	}
+	if _, err := s.NewRangeReader("missing.txt", 0, -1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("NewRangeReader = %v", err)
+	}
+	if err := s.Delete("missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Delete = %v", err)
+	}
+}
+
+// newS3Storage 啟動 gofakes3，回傳的函式每次建立新的 bucket
+func newS3Storage(t *testing.T) func(t *testing.T) storage.S3Storage {
+	backend := s3mem.New()
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	var count int32
+	return func(t *testing.T) storage.S3Storage {
+		bucket := fmt.Sprintf("bucket-%d", atomic.AddInt32(&count, 1))
+		if err := backend.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+		conf := &storage.S3Conf{
+			Endpoint:   strings.TrimPrefix(server.URL, "http://"),
+			Region:     "us-east-1",
+			AccessKey:  "test",
+			SecretKey:  "test",
+			Bucket:     bucket,
+			DisableSSL: true,
+			PathStyle:  true,
+			// gofakes3 不支援 aws-chunked 編碼
+			UnsignedPayload: true,
+		}
+		s, err := conf.NewStorage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+}
+
+// TestS3Api 以 S3Storage 存取 service.NewS3Api，上傳使用 aws-chunked 編碼驗證每一段的簽章
+func TestS3Api(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		handler, err := service.NewS3Api(&storage.Config{
+			Channels: storage.NewChannelRegistry(map[string]*storage.ChannelConf{"test": {
+				Type:      storage.ChannelMemory,
+				S3ApiKeys: []storage.S3ApiKey{{AccessKey: "access", SecretKey: "secret"}},
+			}}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { handler.Close() })
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		conf := &storage.S3Conf{
+			Endpoint:   strings.TrimPrefix(server.URL, "http://"),
+			Region:     "us-east-1",
+			AccessKey:  "access",
+			SecretKey:  "secret",
+			Bucket:     "test",
+			DisableSSL: true,
+			PathStyle:  true,
+		}
+		s, err := conf.NewStorage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// newSftpServer 啟動只接受 test/secret 及 clientKey 登入的 ssh 伺服器，以 sftp subsystem 存取本機檔案，回傳位址及主機公鑰
+func newSftpServer(t *testing.T, clientKey ssh.PublicKey) (string, string) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "test" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", c.User())
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey != nil && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %q", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, config)
+		}
+	}()
+	return lis.Addr().String(), string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+func serveSftp(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				// subsystem 的 payload 為 uint32 長度加上名稱
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func newSftpStorage(t *testing.T, conf *storage.SftpConf) storage.Storage {
+	conf.Root = t.TempDir()
+	s, err := conf.NewStorage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSftp(t *testing.T) {
+	addr, hostKey := newSftpServer(t, nil)
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return newSftpStorage(t, &storage.SftpConf{
+			Host:     addr,
+			User:     "test",
+			Password: "secret",
+			HostKey:  hostKey,
+		})
+	})
+}
+
+func TestSftpPrivateKey(t *testing.T) {
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(clientKey, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(clientKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, hostKey := newSftpServer(t, publicKey)
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return newSftpStorage(t, &storage.SftpConf{
+			Host:       addr,
+			User:       "test",
+			PrivateKey: string(pem.EncodeToMemory(block)),
+			Passphrase: "passphrase",
+			HostKey:    hostKey,
+			MaxConns:   1,
+		})
+	})
+}
@@ -0,0 +1,640 @@
+// Package storagetest 提供所有 storage 實作共用的一致性測試
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/94peter/storage"
+)
+
+// Factory 回傳一個空的 Storage，每個子測試都會呼叫一次，釋放資源請使用 t.Cleanup
+type Factory func(t *testing.T) storage.Storage
+
+// RunConformance 檢查 Storage 的所有方法，實作 ConditionalStorage、ObjectLister、PrefixDeleter 或 BatchStorage 時一併檢查
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"SaveGet", testSaveGet},
+		{"SaveByReader", testSaveByReader},
+		{"EmptyFile", testEmptyFile},
+		{"BinaryData", testBinaryData},
+		{"LargeFile", testLargeFile},
+		{"UnicodeKey", testUnicodeKey},
+		{"NestedKey", testNestedKey},
+		{"Overwrite", testOverwrite},
+		{"MissingKey", testMissingKey},
+		{"InvalidKey", testInvalidKey},
+		{"Delete", testDelete},
+		{"List", testList},
+		{"ConcurrentSave", testConcurrentSave},
+		{"ConditionalSave", testConditionalSave},
+		{"ConditionalDelete", testConditionalDelete},
+		{"ListObjects", testListObjects},
+		{"Objects", testObjects},
+		{"ObjectsOrder", testObjectsOrder},
+		{"ListMatch", testListMatch},
+		{"DeletePrefix", testDeletePrefix},
+		{"RangeReader", testRangeReader},
+		{"Batch", testBatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory(t))
+		})
+	}
+}
+
+func mustSave(t *testing.T, s storage.Storage, key string, data []byte) {
+	t.Helper()
+	if _, err := s.Save(key, data); err != nil {
+		t.Fatalf("Save(%q): %v", key, err)
+	}
+}
+
+func assertContent(t *testing.T, s storage.Storage, key string, want []byte) {
+	t.Helper()
+	got, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Get(%q) = %d bytes, want %d bytes", key, len(got), len(want))
+	}
+}
+
+func assertExist(t *testing.T, s storage.Storage, key string, want bool) {
+	t.Helper()
+	exist, err := s.FileExist(key)
+	if err != nil {
+		t.Fatalf("FileExist(%q): %v", key, err)
+	}
+	if exist != want {
+		t.Fatalf("FileExist(%q) = %v, want %v", key, exist, want)
+	}
+}
+
+func testSaveGet(t *testing.T, s storage.Storage) {
+	mustSave(t, s, "hello.txt", []byte("hello world"))
+	assertContent(t, s, "hello.txt", []byte("hello world"))
+	assertExist(t, s, "hello.txt", true)
+}
+
+func testSaveByReader(t *testing.T, s storage.Storage) {
+	if _, err := s.SaveByReader("reader.txt", strings.NewReader("from reader")); err != nil {
+		t.Fatalf("SaveByReader: %v", err)
+	}
+	assertContent(t, s, "reader.txt", []byte("from reader"))
+}
+
+func testEmptyFile(t *testing.T, s storage.Storage) {
+	mustSave(t, s, "empty.txt", []byte{})
+	assertContent(t, s, "empty.txt", []byte{})
+	assertExist(t, s, "empty.txt", true)
+
+	if _, err := s.SaveByReader("empty-reader.txt", bytes.NewReader(nil)); err != nil {
+		t.Fatalf("SaveByReader: %v", err)
+	}
+	assertContent(t, s, "empty-reader.txt", []byte{})
+}
+
+func testBinaryData(t *testing.T, s storage.Storage) {
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	mustSave(t, s, "binary.bin", data)
+	assertContent(t, s, "binary.bin", data)
+}
+
+func testLargeFile(t *testing.T, s storage.Storage) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	if _, err := s.SaveByReader("large.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("SaveByReader: %v", err)
+	}
+	assertContent(t, s, "large.bin", data)
+}
+
+func testUnicodeKey(t *testing.T, s storage.Storage) {
+	for _, key := range []string{"資料夾/檔案 名稱.txt", "émoji-😀.txt", "スペース と 記号 (1)+[2].txt"} {
+		mustSave(t, s, key, []byte(key))
+		assertContent(t, s, key, []byte(key))
+		assertExist(t, s, key, true)
+	}
+}
+
+func testNestedKey(t *testing.T, s storage.Storage) {
+	mustSave(t, s, "a/b/c/d.txt", []byte("deep"))
+	assertContent(t, s, "a/b/c/d.txt", []byte("deep"))
+	assertExist(t, s, "a/b/c/d.txt", true)
+	assertExist(t, s, "a/b/c/e.txt", false)
+}
+
+func testOverwrite(t *testing.T, s storage.Storage) {
+	mustSave(t, s, "overwrite.txt", []byte("first version"))
+	mustSave(t, s, "overwrite.txt", []byte("second"))
+	assertContent(t, s, "overwrite.txt", []byte("second"))
+
+	if _, err := s.SaveByReader("overwrite.txt", strings.NewReader("")); err != nil {
+		t.Fatalf("SaveByReader: %v", err)
+	}
+	assertContent(t, s, "overwrite.txt", []byte{})
+}
+
+func testMissingKey(t *testing.T, s storage.Storage) {
+	assertExist(t, s, "missing.txt", false)
+	if _, err := s.Get("missing.txt"); err == nil {
+		t.Fatal("Get missing key: want error")
+	}
+	if err := s.Delete("missing.txt"); err == nil {
+		t.Fatal("Delete missing key: want error")
+	}
+	if list, err := s.List("missing/"); err != nil || len(list) != 0 {
+		t.Fatalf("List missing dir = %v, %v; want empty", list, err)
+	}
+}
+
+// testInvalidKey 只檢查會拒絕 "../" 的實作，拒絕時各方法都需回傳 storage.ErrInvalidKey
+func testInvalidKey(t *testing.T, s storage.Storage) {
+	const key = "../escape.txt"
+	_, err := s.Save(key, []byte("escape"))
+	if err == nil {
+		t.Skip("backend accepts any key")
+	}
+	assertInvalidKey := func(name string, err error) {
+		t.Helper()
+		if !errors.Is(err, storage.ErrInvalidKey) {
+			t.Fatalf("%s(%q) = %v, want ErrInvalidKey", name, key, err)
+		}
+	}
+	assertInvalidKey("Save", err)
+	_, err = s.Get(key)
+	assertInvalidKey("Get", err)
+	_, err = s.FileExist(key)
+	assertInvalidKey("FileExist", err)
+	assertInvalidKey("Delete", s.Delete(key))
+	if lister, ok := s.(storage.ObjectLister); ok {
+		_, err = lister.ListObjects("../", nil)
+		assertInvalidKey("ListObjects", err)
+	}
+	if deleter, ok := s.(storage.PrefixDeleter); ok {
+		assertInvalidKey("DeletePrefix", deleter.DeletePrefix("../"))
+	}
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	mustSave(t, s, "dir/delete.txt", []byte("bye"))
+	mustSave(t, s, "dir/keep.txt", []byte("keep"))
+	if err := s.Delete("dir/delete.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertExist(t, s, "dir/delete.txt", false)
+	if _, err := s.Get("dir/delete.txt"); err == nil {
+		t.Fatal("Get deleted key: want error")
+	}
+	assertContent(t, s, "dir/keep.txt", []byte("keep"))
+}
+
+// testList 只檢查各實作一致的部分：子目錄在 hd 以 "sub/" 表示，在 gcs 則列出其下的檔案
+func testList(t *testing.T, s storage.Storage) {
+	mustSave(t, s, "list/a.txt", []byte("a"))
+	mustSave(t, s, "list/b.txt", []byte("b"))
+	mustSave(t, s, "list/sub/c.txt", []byte("c"))
+	mustSave(t, s, "other/d.txt", []byte("d"))
+
+	list, err := s.List("list/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := map[string]bool{}
+	for _, key := range list {
+		if !strings.HasPrefix(key, "list/") {
+			t.Fatalf("List(%q) returned %q", "list/", key)
+		}
+		got[key] = true
+	}
+	if !got["list/a.txt"] || !got["list/b.txt"] {
+		t.Fatalf("List(%q) = %v, missing files", "list/", list)
+	}
+	if !got["list/sub/"] && !got["list/sub/c.txt"] {
+		t.Fatalf("List(%q) = %v, missing sub directory", "list/", list)
+	}
+}
+
+func testConcurrentSave(t *testing.T, s storage.Storage) {
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("concurrent/%d.txt", i)
+			if _, err := s.Save(key, []byte(key)); err != nil {
+				errs <- fmt.Errorf("Save(%q): %w", key, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("concurrent/%d.txt", i)
+		assertContent(t, s, key, []byte(key))
+	}
+}
+
+func testConditionalSave(t *testing.T, s storage.Storage) {
+	cs, ok := s.(storage.ConditionalStorage)
+	if !ok {
+		t.Skip("not a ConditionalStorage")
+	}
+	generation, err := cs.Generation("cond.txt")
+	if err != nil || generation != 0 {
+		t.Fatalf("Generation of missing key = %d, %v; want 0", generation, err)
+	}
+	if _, err = cs.SaveIfNotExists("cond.txt", []byte("v1")); err != nil {
+		t.Fatalf("SaveIfNotExists: %v", err)
+	}
+	if _, err = cs.SaveIfNotExists("cond.txt", []byte("v2")); !errors.Is(err, storage.ErrPreconditionFailed) {
+		t.Fatalf("SaveIfNotExists existing key: %v, want ErrPreconditionFailed", err)
+	}
+	assertContent(t, s, "cond.txt", []byte("v1"))
+
+	generation, err = cs.Generation("cond.txt")
+	if err != nil || generation == 0 {
+		t.Fatalf("Generation = %d, %v; want non-zero", generation, err)
+	}
+	if _, err = cs.SaveIfMatch("cond.txt", []byte("v2"), generation+1); !errors.Is(err, storage.ErrPreconditionFailed) {
+		t.Fatalf("SaveIfMatch stale generation: %v, want ErrPreconditionFailed", err)
+	}
+	if _, err = cs.SaveIfMatch("cond.txt", []byte("v2"), generation); err != nil {
+		t.Fatalf("SaveIfMatch: %v", err)
+	}
+	assertContent(t, s, "cond.txt", []byte("v2"))
+
+	next, err := cs.Generation("cond.txt")
+	if err != nil || next == generation {
+		t.Fatalf("Generation after SaveIfMatch = %d, %v; want changed from %d", next, err, generation)
+	}
+}
+
+func testConditionalDelete(t *testing.T, s storage.Storage) {
+	cs, ok := s.(storage.ConditionalStorage)
+	if !ok {
+		t.Skip("not a ConditionalStorage")
+	}
+	mustSave(t, s, "cond.txt", []byte("v1"))
+	generation, err := cs.Generation("cond.txt")
+	if err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	if err = cs.DeleteIfMatch("cond.txt", generation+1); !errors.Is(err, storage.ErrPreconditionFailed) {
+		t.Fatalf("DeleteIfMatch stale generation: %v, want ErrPreconditionFailed", err)
+	}
+	if err = cs.DeleteIfMatch("cond.txt", 0); !errors.Is(err, storage.ErrPreconditionFailed) {
+		t.Fatalf("DeleteIfMatch generation 0: %v, want ErrPreconditionFailed", err)
+	}
+	assertExist(t, s, "cond.txt", true)
+	if err = cs.DeleteIfMatch("cond.txt", generation); err != nil {
+		t.Fatalf("DeleteIfMatch: %v", err)
+	}
+	assertExist(t, s, "cond.txt", false)
+}
+
+func saveListFixture(t *testing.T, s storage.Storage) {
+	for _, key := range []string{"tree/a.txt", "tree/b.txt", "tree/c/d.txt", "tree/c/e/f.txt", "tree/g/h.txt", "treetop.txt"} {
+		mustSave(t, s, key, []byte(key))
+	}
+}
+
+func objectKeys(objects []storage.ObjectInfo) []string {
+	keys := []string{}
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	return keys
+}
+
+func assertStrings(t *testing.T, name string, got []string, want []string) {
+	t.Helper()
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func testListObjects(t *testing.T, s storage.Storage) {
+	lister, ok := s.(storage.ObjectLister)
+	if !ok {
+		t.Skip("not an ObjectLister")
+	}
+	saveListFixture(t, s)
+
+	page, err := lister.ListObjects("tree/", nil)
+	if err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+	assertStrings(t, "objects", objectKeys(page.Objects), []string{"tree/a.txt", "tree/b.txt"})
+	assertStrings(t, "prefixes", page.Prefixes, []string{"tree/c/", "tree/g/"})
+	for _, o := range page.Objects {
+		if o.Size != int64(len(o.Key)) {
+			t.Fatalf("size of %q = %d, want %d", o.Key, o.Size, len(o.Key))
+		}
+	}
+
+	page, err = lister.ListObjects("tree/", &storage.ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("ListObjects recursive: %v", err)
+	}
+	assertStrings(t, "recursive objects", objectKeys(page.Objects),
+		[]string{"tree/a.txt", "tree/b.txt", "tree/c/d.txt", "tree/c/e/f.txt", "tree/g/h.txt"})
+
+	// 分頁逐頁取回的結果需與一次取回的相同，模擬服務可能忽略 PageSize 一次回傳全部
+	var keys []string
+	opts := &storage.ListOptions{Recursive: true, PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("too many pages")
+		}
+		page, err = lister.ListObjects("tree/", opts)
+		if err != nil {
+			t.Fatalf("ListObjects page %d: %v", pages, err)
+		}
+		keys = append(keys, objectKeys(page.Objects)...)
+		if page.NextToken == "" {
+			break
+		}
+		opts.PageToken = page.NextToken
+	}
+	assertStrings(t, "paged objects", keys,
+		[]string{"tree/a.txt", "tree/b.txt", "tree/c/d.txt", "tree/c/e/f.txt", "tree/g/h.txt"})
+
+	page, err = lister.ListObjects("tree/", &storage.ListOptions{Recursive: true, StartOffset: "tree/b", EndOffset: "tree/g"})
+	if err != nil {
+		t.Fatalf("ListObjects offset: %v", err)
+	}
+	assertStrings(t, "offset objects", objectKeys(page.Objects), []string{"tree/b.txt", "tree/c/d.txt", "tree/c/e/f.txt"})
+
+	page, err = lister.ListObjects("missing/", nil)
+	if err != nil || len(page.Objects) != 0 || len(page.Prefixes) != 0 {
+		t.Fatalf("ListObjects missing prefix = %+v, %v; want empty", page, err)
+	}
+}
+
+func testObjects(t *testing.T, s storage.Storage) {
+	lister, ok := s.(storage.ObjectLister)
+	if !ok {
+		t.Skip("not an ObjectLister")
+	}
+	saveListFixture(t, s)
+
+	var keys, prefixes []string
+	it := lister.Objects("tree/", nil)
+	defer it.Stop()
+	for {
+		o, err := it.Next()
+		if err == storage.IteratorDone {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if o.Prefix != "" {
+			prefixes = append(prefixes, o.Prefix)
+		} else {
+			keys = append(keys, o.Key)
+		}
+	}
+	assertStrings(t, "objects", keys, []string{"tree/a.txt", "tree/b.txt"})
+	assertStrings(t, "prefixes", prefixes, []string{"tree/c/", "tree/g/"})
+
+	// 提前 Stop 後 Next 需回傳 IteratorDone
+	it = lister.Objects("tree/", &storage.ListOptions{Recursive: true})
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	it.Stop()
+	if _, err := it.Next(); err != storage.IteratorDone {
+		t.Fatalf("Next after Stop: %v, want IteratorDone", err)
+	}
+}
+
+// testObjectsOrder Objects 與 gcs 相同依 key 的順序回傳
+func testObjectsOrder(t *testing.T, s storage.Storage) {
+	lister, ok := s.(storage.ObjectLister)
+	if !ok {
+		t.Skip("not an ObjectLister")
+	}
+	keys := []string{"order/a.txt", "order/a/b.txt", "order/a-b", "order/b/c/d.txt", "order/b.txt", "order/z"}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("order/k%02d", i))
+	}
+	for _, key := range keys {
+		mustSave(t, s, key, []byte(key))
+	}
+	list := func(opts *storage.ListOptions) (keys []string, prefixes []string) {
+		t.Helper()
+		it := lister.Objects("order/", opts)
+		defer it.Stop()
+		for {
+			o, err := it.Next()
+			if err == storage.IteratorDone {
+				return keys, prefixes
+			}
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if o.Prefix != "" {
+				prefixes = append(prefixes, o.Prefix)
+			} else {
+				keys = append(keys, o.Key)
+			}
+		}
+	}
+	assertOrder := func(name string, got []string, want []string) {
+		t.Helper()
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s = %v, want %v", name, got, want)
+		}
+	}
+
+	want := append([]string{}, keys...)
+	sort.Strings(want)
+	got, _ := list(&storage.ListOptions{Recursive: true})
+	assertOrder("recursive", got, want)
+
+	// prefix 與物件之間的相對順序依實作而定，gcs 每一頁先回傳物件再回傳 prefix
+	want = []string{"order/a-b", "order/a.txt", "order/b.txt"}
+	for i := 0; i < 20; i++ {
+		want = append(want, fmt.Sprintf("order/k%02d", i))
+	}
+	want = append(want, "order/z")
+	got, prefixes := list(nil)
+	assertOrder("delimiter objects", got, want)
+	assertOrder("delimiter prefixes", prefixes, []string{"order/a/", "order/b/"})
+}
+
+func testListMatch(t *testing.T, s storage.Storage) {
+	matcher, ok := s.(storage.ObjectMatcher)
+	if !ok {
+		t.Skip("not an ObjectMatcher")
+	}
+	// 內容長度 1 到 6 作為 size 篩選的依據
+	for i, key := range []string{"glob/a.txt", "glob/b.log", "glob/sub/c.txt", "glob/sub/deep/d.txt", "glob/資料/e.txt", "glob/x.txt"} {
+		mustSave(t, s, key, bytes.Repeat([]byte("x"), i+1))
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	tests := []struct {
+		pattern string
+		filters []storage.ListFilter
+		want    []string
+	}{
+		{"glob/*.txt", nil, []string{"glob/a.txt", "glob/x.txt"}},
+		{"glob/**.txt", nil, []string{"glob/a.txt", "glob/sub/c.txt", "glob/sub/deep/d.txt", "glob/x.txt", "glob/資料/e.txt"}},
+		{"glob/**/d.txt", nil, []string{"glob/sub/deep/d.txt"}},
+		{"glob/{a,b}.*", nil, []string{"glob/a.txt", "glob/b.log"}},
+		{"glob/[!x].txt", nil, []string{"glob/a.txt"}},
+		{"glob/資料/*.txt", nil, []string{"glob/資料/e.txt"}},
+		{"glob/**", []storage.ListFilter{storage.MinSize(3), storage.MaxSize(5)}, []string{"glob/sub/c.txt", "glob/sub/deep/d.txt", "glob/資料/e.txt"}},
+		{"glob/*", []storage.ListFilter{storage.UpdatedAfter(hourAgo)}, []string{"glob/a.txt", "glob/b.log", "glob/x.txt"}},
+		{"glob/*", []storage.ListFilter{storage.UpdatedBefore(hourAgo)}, nil},
+	}
+	for _, tt := range tests {
+		objects, err := matcher.ListMatch(tt.pattern, tt.filters...)
+		if err != nil {
+			t.Fatalf("ListMatch(%q): %v", tt.pattern, err)
+		}
+		assertStrings(t, fmt.Sprintf("ListMatch(%q, %+v)", tt.pattern, tt.filters), objectKeys(objects), tt.want)
+	}
+	if _, err := matcher.ListMatch("glob/{a,b"); err == nil {
+		t.Fatal("ListMatch with unclosed brace: want error")
+	}
+}
+
+func testDeletePrefix(t *testing.T, s storage.Storage) {
+	deleter, ok := s.(storage.PrefixDeleter)
+	if !ok {
+		t.Skip("not a PrefixDeleter")
+	}
+	saveListFixture(t, s)
+	if err := deleter.DeletePrefix("tree/c/"); err != nil {
+		t.Fatalf("DeletePrefix: %v", err)
+	}
+	for _, key := range []string{"tree/c/d.txt", "tree/c/e/f.txt"} {
+		assertExist(t, s, key, false)
+	}
+	for _, key := range []string{"tree/a.txt", "tree/g/h.txt", "treetop.txt"} {
+		assertExist(t, s, key, true)
+	}
+	if err := deleter.DeletePrefix(""); err == nil {
+		t.Fatal("DeletePrefix empty prefix: want error")
+	}
+}
+
+func testRangeReader(t *testing.T, s storage.Storage) {
+	reader, ok := s.(storage.ObjectReader)
+	if !ok {
+		t.Skip("not an ObjectReader")
+	}
+	mustSave(t, s, "range.txt", []byte("0123456789"))
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "0123456789"},
+		{3, -1, "3456789"},
+		{2, 4, "2345"},
+		{8, 10, "89"},
+	}
+	for _, tt := range tests {
+		rc, err := reader.NewRangeReader("range.txt", tt.offset, tt.length)
+		if err != nil {
+			t.Fatalf("NewRangeReader(%d, %d): %v", tt.offset, tt.length, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != tt.want {
+			t.Fatalf("NewRangeReader(%d, %d) = %q, %v; want %q", tt.offset, tt.length, data, err, tt.want)
+		}
+	}
+	if _, err := reader.NewRangeReader("missing.txt", 0, -1); err == nil {
+		t.Fatal("NewRangeReader missing key: want error")
+	}
+}
+
+func testBatch(t *testing.T, s storage.Storage) {
+	batch, ok := s.(storage.BatchStorage)
+	if !ok {
+		t.Skip("not a BatchStorage")
+	}
+	mustSave(t, s, "batch/a.txt", []byte("a"))
+	mustSave(t, s, "batch/b.txt", []byte("bb"))
+	keys := []string{"batch/a.txt", "batch/missing.txt", "batch/b.txt"}
+	assertKeys := func(name string, results []storage.BatchResult, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := make([]string, len(results))
+		for i := range results {
+			got[i] = results[i].Key
+		}
+		// 結果必須與 keys 的順序相同
+		if strings.Join(got, ",") != strings.Join(keys, ",") {
+			t.Fatalf("%s keys = %v, want %v", name, got, keys)
+		}
+	}
+
+	results, err := batch.BatchExist(keys)
+	assertKeys("BatchExist", results, err)
+	for i, want := range []bool{true, false, true} {
+		if results[i].Exist != want || results[i].Err != nil {
+			t.Fatalf("BatchExist %s = %v, %v; want %v", keys[i], results[i].Exist, results[i].Err, want)
+		}
+	}
+
+	results, err = batch.BatchStat(keys)
+	assertKeys("BatchStat", results, err)
+	for i, size := range []int64{1, -1, 2} {
+		r := results[i]
+		if r.Err != nil {
+			t.Fatalf("BatchStat %s: %v", keys[i], r.Err)
+		}
+		if size < 0 {
+			if r.Exist || r.Object != nil {
+				t.Fatalf("BatchStat %s = %+v; want not exist", keys[i], r.Object)
+			}
+			continue
+		}
+		if !r.Exist || r.Object == nil || r.Object.Key != keys[i] || r.Object.Size != size {
+			t.Fatalf("BatchStat %s = %+v; want size %d", keys[i], r.Object, size)
+		}
+	}
+
+	results, err = batch.BatchDelete(keys)
+	assertKeys("BatchDelete", results, err)
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Fatalf("BatchDelete: %v, %v", results[0].Err, results[2].Err)
+	}
+	if results[1].Err == nil {
+		t.Fatal("BatchDelete missing key: want error")
+	}
+	assertExist(t, s, "batch/a.txt", false)
+	assertExist(t, s, "batch/b.txt", false)
+
+	if results, err := batch.BatchExist(nil); err != nil || len(results) != 0 {
+		t.Fatalf("BatchExist no keys = %v, %v; want empty", results, err)
+	}
+}